@@ -0,0 +1,256 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// DivisionScale is the number of fractional digits kept by Decimal.Quo when
+// the quotient does not terminate.
+const DivisionScale = 18
+
+// Decimal is an exact decimal number equal to value * 10^-scale.
+// The zero value is 0.
+type Decimal struct {
+	value *big.Int
+	scale int32
+}
+
+type RoundingMode int
+
+const (
+	RoundHalfEven RoundingMode = iota // to nearest, ties to even
+	RoundHalfUp                       // to nearest, ties away from zero
+	RoundHalfDown                     // to nearest, ties toward zero
+	RoundUp                           // away from zero
+	RoundDown                         // toward zero
+	RoundCeiling                      // toward positive infinity
+	RoundFloor                        // toward negative infinity
+)
+
+var roundingModes = map[string]RoundingMode{
+	"half_even": RoundHalfEven,
+	"half_up":   RoundHalfUp,
+	"half_down": RoundHalfDown,
+	"up":        RoundUp,
+	"down":      RoundDown,
+	"ceiling":   RoundCeiling,
+	"floor":     RoundFloor,
+}
+
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	mode, exists := roundingModes[s]
+	if !exists {
+		return 0, fmt.Errorf("invalid rounding mode: %s", s)
+	}
+	return mode, nil
+}
+
+func (m RoundingMode) String() string {
+	for name, mode := range roundingModes {
+		if mode == m {
+			return name
+		}
+	}
+	return fmt.Sprintf("RoundingMode(%d)", int(m))
+}
+
+func NewDecimal(value *big.Int, scale int32) Decimal {
+	return Decimal{value: new(big.Int).Set(value), scale: scale}
+}
+
+func DecimalFromInt(value int64) Decimal {
+	return Decimal{value: big.NewInt(value)}
+}
+
+// ParseDecimal parses a plain decimal such as "10", "-3.25" or ".5".
+func ParseDecimal(s string) (Decimal, error) {
+	digits := strings.TrimSpace(s)
+	sign := ""
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		sign, digits = digits[:1], digits[1:]
+	}
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole+fraction == "" || strings.Trim(whole+fraction, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("invalid decimal: %q", s)
+	}
+	value, _ := new(big.Int).SetString(sign+whole+fraction, 10)
+	return Decimal{value: value, scale: int32(len(fraction))}, nil
+}
+
+func (d Decimal) int() *big.Int {
+	if d.value == nil {
+		return new(big.Int)
+	}
+	return d.value
+}
+
+// Scale is the number of fractional digits d is stored with.
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+// Unscaled returns the integer value of d before the decimal point is placed.
+func (d Decimal) Unscaled() *big.Int {
+	return new(big.Int).Set(d.int())
+}
+
+// rescale returns d with at least scale fractional digits. It never rounds.
+func (d Decimal) rescale(scale int32) Decimal {
+	if scale <= d.scale {
+		return d
+	}
+	value := new(big.Int).Mul(d.int(), pow10(scale-d.scale))
+	return Decimal{value: value, scale: scale}
+}
+
+func align(a, b Decimal) (Decimal, Decimal) {
+	if a.scale < b.scale {
+		return a.rescale(b.scale), b
+	}
+	return a, b.rescale(a.scale)
+}
+
+func (d Decimal) Add(o Decimal) Decimal {
+	a, b := align(d, o)
+	return Decimal{value: new(big.Int).Add(a.int(), b.int()), scale: a.scale}
+}
+
+func (d Decimal) Sub(o Decimal) Decimal {
+	a, b := align(d, o)
+	return Decimal{value: new(big.Int).Sub(a.int(), b.int()), scale: a.scale}
+}
+
+func (d Decimal) Mul(o Decimal) Decimal {
+	return Decimal{value: new(big.Int).Mul(d.int(), o.int()), scale: d.scale + o.scale}
+}
+
+// Quo returns d / o, exactly when the quotient terminates within
+// DivisionScale fractional digits and rounded half-even otherwise.
+func (d Decimal) Quo(o Decimal) (Decimal, error) {
+	if o.Sign() == 0 {
+		return Decimal{}, fmt.Errorf("division by zero")
+	}
+	scale := d.scale
+	if o.scale > scale {
+		scale = o.scale
+	}
+	scale += DivisionScale
+	// d / o = (d.value * 10^(scale+2-d.scale+o.scale)) / o.value * 10^-(scale+2),
+	// computed with two guard digits and then rounded to scale.
+	numerator := new(big.Int).Mul(d.int(), pow10(scale+2-d.scale+o.scale))
+	quotient, remainder := new(big.Int).QuoRem(numerator, o.int(), new(big.Int))
+	if remainder.Sign() != 0 {
+		// Mark the quotient as inexact so a tie cannot be mistaken for exact.
+		quotient.Mul(quotient, big.NewInt(10))
+		quotient.Add(quotient, big.NewInt(int64(remainder.Sign()*o.int().Sign())))
+		return Decimal{value: quotient, scale: scale + 3}.Round(scale, RoundHalfEven).normalize(), nil
+	}
+	return Decimal{value: quotient, scale: scale + 2}.normalize(), nil
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{value: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+func (d Decimal) Abs() Decimal {
+	return Decimal{value: new(big.Int).Abs(d.int()), scale: d.scale}
+}
+
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+func (d Decimal) Cmp(o Decimal) int {
+	a, b := align(d, o)
+	return a.int().Cmp(b.int())
+}
+
+// Round returns d with at most scale fractional digits, rounded with mode.
+func (d Decimal) Round(scale int32, mode RoundingMode) Decimal {
+	if d.scale <= scale {
+		return d
+	}
+	divisor := pow10(d.scale - scale)
+	quotient, remainder := new(big.Int).QuoRem(d.int(), divisor, new(big.Int))
+	if remainder.Sign() == 0 {
+		return Decimal{value: quotient, scale: scale}
+	}
+
+	sign := d.int().Sign()
+	half := new(big.Int).Abs(remainder)
+	half.Mul(half, big.NewInt(2))
+	tie := half.Cmp(divisor)
+
+	away := false
+	switch mode {
+	case RoundUp:
+		away = true
+	case RoundDown:
+		away = false
+	case RoundCeiling:
+		away = sign > 0
+	case RoundFloor:
+		away = sign < 0
+	case RoundHalfUp:
+		away = tie >= 0
+	case RoundHalfDown:
+		away = tie > 0
+	case RoundHalfEven:
+		away = tie > 0 || (tie == 0 && quotient.Bit(0) == 1)
+	}
+	if away {
+		quotient.Add(quotient, big.NewInt(int64(sign)))
+	}
+	return Decimal{value: quotient, scale: scale}
+}
+
+// Rescale returns d with exactly scale fractional digits, rounding with mode
+// when digits have to be dropped.
+func (d Decimal) Rescale(scale int32, mode RoundingMode) Decimal {
+	return d.Round(scale, mode).rescale(scale)
+}
+
+// Exact reports whether d can be written with scale fractional digits
+// without rounding.
+func (d Decimal) Exact(scale int32) bool {
+	return d.Round(scale, RoundDown).Cmp(d) == 0
+}
+
+// normalize drops trailing fractional zeros.
+func (d Decimal) normalize() Decimal {
+	value := new(big.Int).Set(d.int())
+	scale := d.scale
+	ten := big.NewInt(10)
+	remainder := new(big.Int)
+	for scale > 0 {
+		quotient, _ := new(big.Int).QuoRem(value, ten, remainder)
+		if remainder.Sign() != 0 {
+			break
+		}
+		value = quotient
+		scale--
+	}
+	return Decimal{value: value, scale: scale}
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.int()).String()
+	sign := ""
+	if d.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale <= 0 {
+		return sign + digits + strings.Repeat("0", int(-d.scale))
+	}
+	if len(digits) <= int(d.scale) {
+		digits = strings.Repeat("0", int(d.scale)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(d.scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decimal(t *testing.T, s string) Decimal {
+	d, err := ParseDecimal(s)
+	assert.Nil(t, err)
+	return d
+}
+
+func TestParseDecimal(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10":     "10",
+		"-3.25":  "-3.25",
+		".5":     "0.5",
+		"+0.050": "0.050",
+	} {
+		assert.Equal(t, expected, decimal(t, input).String(), input)
+	}
+	for _, input := range []string{"", "-", "1.2.3", "12a", "1e3"} {
+		_, err := ParseDecimal(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	a, b := decimal(t, "10.50"), decimal(t, "0.125")
+	assert.Equal(t, "10.625", a.Add(b).String())
+	assert.Equal(t, "10.375", a.Sub(b).String())
+	assert.Equal(t, "1.31250", a.Mul(b).String())
+
+	quotient, err := a.Quo(b)
+	assert.Nil(t, err)
+	assert.Equal(t, "84", quotient.String())
+
+	quotient, err = DecimalFromInt(2).Quo(DecimalFromInt(3))
+	assert.Nil(t, err)
+	assert.Equal(t, "0.666666666666666667", quotient.String())
+
+	_, err = a.Quo(Decimal{})
+	assert.NotNil(t, err)
+}
+
+func TestDecimalRound(t *testing.T) {
+	tests := []struct {
+		value string
+		mode  RoundingMode
+		want  string
+	}{
+		{"2.5", RoundHalfEven, "2"},
+		{"3.5", RoundHalfEven, "4"},
+		{"-2.5", RoundHalfUp, "-3"},
+		{"2.5", RoundHalfDown, "2"},
+		{"2.51", RoundHalfDown, "3"},
+		{"2.1", RoundUp, "3"},
+		{"-2.9", RoundDown, "-2"},
+		{"-2.1", RoundCeiling, "-2"},
+		{"-2.1", RoundFloor, "-3"},
+		{"7", RoundFloor, "7"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, decimal(t, tt.value).Round(0, tt.mode).String(), "%s %s", tt.value, tt.mode)
+	}
+	assert.True(t, decimal(t, "1.20").Exact(1))
+	assert.False(t, decimal(t, "1.25").Exact(1))
+}
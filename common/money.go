@@ -0,0 +1,165 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// NoCurrency is the ISO 4217 code for transactions that involve no currency.
+// It has no minor units and is used for amounts that don't name a currency.
+const NoCurrency = "XXX"
+
+// Currency is an ISO 4217 currency together with the number of minor units
+// (fractional digits) its amounts are kept in.
+type Currency struct {
+	Code  string
+	Scale int32
+}
+
+var (
+	currenciesMu sync.RWMutex
+	currencies   = map[string]int32{
+		"AED": 2, "ARS": 2, "AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2,
+		"CLP": 0, "CNY": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2,
+		"HUF": 2, "IDR": 2, "ILS": 2, "INR": 2, "IQD": 3, "ISK": 0, "JOD": 3,
+		"JPY": 0, "KRW": 0, "KWD": 3, "LYD": 3, "MXN": 2, "MYR": 2, "NOK": 2,
+		"NZD": 2, "OMR": 3, "PHP": 2, "PLN": 2, "SAR": 2, "SEK": 2, "SGD": 2,
+		"THB": 2, "TND": 3, "TRY": 2, "TWD": 2, "UGX": 0, "USD": 2, "VND": 0,
+		"XAF": 0, "XOF": 0, "ZAR": 2,
+		NoCurrency: 0,
+	}
+)
+
+// LookupCurrency returns the currency with the given ISO 4217 code.
+func LookupCurrency(code string) (Currency, error) {
+	currenciesMu.RLock()
+	defer currenciesMu.RUnlock()
+	scale, exists := currencies[code]
+	if !exists {
+		return Currency{}, fmt.Errorf("unknown currency: %s", code)
+	}
+	return Currency{Code: code, Scale: scale}, nil
+}
+
+// RegisterCurrency adds or replaces a currency, for codes missing from the
+// built-in table or for non-ISO units such as loyalty points.
+func RegisterCurrency(code string, scale int32) {
+	currenciesMu.Lock()
+	defer currenciesMu.Unlock()
+	currencies[code] = scale
+}
+
+func (c Currency) String() string {
+	return c.Code
+}
+
+// Money is an amount held in minor units of a currency, so 10.50 USD has
+// Units 1050.
+type Money struct {
+	Units    *big.Int
+	Currency Currency
+}
+
+func NewMoney(units *big.Int, currency Currency) Money {
+	return Money{Units: new(big.Int).Set(units), Currency: currency}
+}
+
+// Zero returns no money in currency.
+func Zero(currency Currency) Money {
+	return Money{Units: new(big.Int), Currency: currency}
+}
+
+// ParseMoney parses a decimal amount such as "10.50" in the currency code.
+// Amounts with more fractional digits than the currency allows are rejected.
+func ParseMoney(amount string, code string) (Money, error) {
+	currency, err := LookupCurrency(code)
+	if err != nil {
+		return Money{}, err
+	}
+	decimal, err := ParseDecimal(amount)
+	if err != nil {
+		return Money{}, err
+	}
+	return MoneyFromDecimal(decimal, currency)
+}
+
+// MoneyFromDecimal converts decimal to money, failing when it has more
+// fractional digits than the currency allows.
+func MoneyFromDecimal(decimal Decimal, currency Currency) (Money, error) {
+	if !decimal.Exact(currency.Scale) {
+		return Money{}, fmt.Errorf("%s has more than %d decimal places for %s", decimal, currency.Scale, currency.Code)
+	}
+	return Money{Units: decimal.Rescale(currency.Scale, RoundDown).Unscaled(), Currency: currency}, nil
+}
+
+func (m Money) units() *big.Int {
+	if m.Units == nil {
+		return new(big.Int)
+	}
+	return m.Units
+}
+
+// Decimal returns m in major units.
+func (m Money) Decimal() Decimal {
+	return NewDecimal(m.units(), m.Currency.Scale)
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", o.Currency, m.Currency)
+	}
+	return Money{Units: new(big.Int).Add(m.units(), o.units()), Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("cannot subtract %s from %s", o.Currency, m.Currency)
+	}
+	return Money{Units: new(big.Int).Sub(m.units(), o.units()), Currency: m.Currency}, nil
+}
+
+func (m Money) Neg() Money {
+	return Money{Units: new(big.Int).Neg(m.units()), Currency: m.Currency}
+}
+
+func (m Money) Sign() int {
+	return m.units().Sign()
+}
+
+// Cmp compares two amounts of the same currency. It panics when the
+// currencies differ, as such amounts have no order.
+func (m Money) Cmp(o Money) int {
+	if m.Currency != o.Currency {
+		panic(fmt.Sprintf("cannot compare %s to %s", o.Currency, m.Currency))
+	}
+	return m.units().Cmp(o.units())
+}
+
+func (m Money) String() string {
+	return m.Decimal().String() + " " + m.Currency.Code
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal().String(), Currency: m.Currency.Code})
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var raw moneyJSON
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	money, err := ParseMoney(raw.Amount, strings.ToUpper(raw.Currency))
+	if err != nil {
+		return err
+	}
+	*m = money
+	return nil
+}
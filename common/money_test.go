@@ -0,0 +1,63 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	m, err := ParseMoney("10.5", "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1050), m.Units.Int64())
+	assert.Equal(t, "10.50 USD", m.String())
+
+	m, err = ParseMoney("1050", "JPY")
+	assert.Nil(t, err)
+	assert.Equal(t, "1050 JPY", m.String())
+
+	m, err = ParseMoney("1.005", "KWD")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1005), m.Units.Int64())
+
+	_, err = ParseMoney("10.5", "JPY")
+	assert.NotNil(t, err)
+	_, err = ParseMoney("1", "ZZZ")
+	assert.NotNil(t, err)
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	a, _ := ParseMoney("10.50", "USD")
+	b, _ := ParseMoney("0.75", "USD")
+	sum, err := a.Add(b)
+	assert.Nil(t, err)
+	assert.Equal(t, "11.25 USD", sum.String())
+	difference, err := b.Sub(a)
+	assert.Nil(t, err)
+	assert.Equal(t, "-9.75 USD", difference.String())
+
+	yen, _ := ParseMoney("10", "JPY")
+	_, err = a.Add(yen)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 1, a.Cmp(b))
+	assert.Equal(t, 0, Money{Currency: a.Currency}.Cmp(Zero(a.Currency)))
+	assert.Panics(t, func() { a.Cmp(yen) })
+}
+
+func TestMoneyJSON(t *testing.T) {
+	m, _ := ParseMoney("10.50", "EUR")
+	b, err := json.Marshal(m)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"amount":"10.50","currency":"EUR"}`, string(b))
+
+	var decoded Money
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, m.String(), decoded.String())
+
+	RegisterCurrency("PTS", 0)
+	points, err := ParseMoney("250", "PTS")
+	assert.Nil(t, err)
+	assert.Equal(t, "250 PTS", points.String())
+}
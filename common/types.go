@@ -15,6 +15,28 @@ const (
 	Credit
 )
 
+func (d Direction) String() string {
+	switch d {
+	case Debit:
+		return "Debit"
+	case Credit:
+		return "Credit"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// Opposite returns the other side of an entry.
+func (d Direction) Opposite() Direction {
+	if d == Debit {
+		return Credit
+	}
+	return Debit
+}
+
+func (d Direction) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
 func (d *Direction) UnmarshalJSON(b []byte) error {
 	switch string(b) {
 	case `"Debit"`:
@@ -34,6 +56,20 @@ const (
 	Posted
 )
 
+func (d Status) String() string {
+	switch d {
+	case Pending:
+		return "Pending"
+	case Posted:
+		return "Posted"
+	}
+	return fmt.Sprintf("Status(%d)", int(d))
+}
+
+func (d Status) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
 func (d *Status) UnmarshalJSON(b []byte) error {
 	switch string(b) {
 	case `"Pending"`:
@@ -45,3 +81,50 @@ func (d *Status) UnmarshalJSON(b []byte) error {
 	}
 	return nil
 }
+
+// AccountType classifies an account. Unclassified accounts behave like
+// debit-normal accounts.
+type AccountType int
+
+const (
+	Unclassified AccountType = iota
+	Asset
+	Liability
+	Equity
+	Income
+	Expense
+)
+
+var accountTypeNames = []string{"unclassified", "asset", "liability", "equity", "income", "expense"}
+
+func (t AccountType) String() string {
+	if t >= 0 && int(t) < len(accountTypeNames) {
+		return accountTypeNames[t]
+	}
+	return fmt.Sprintf("AccountType(%d)", int(t))
+}
+
+// NormalBalance returns the side that increases the balance of an account of
+// type t: Debit for assets and expenses, Credit for liabilities, equity and
+// income.
+func (t AccountType) NormalBalance() Direction {
+	switch t {
+	case Liability, Equity, Income:
+		return Credit
+	}
+	return Debit
+}
+
+func (t AccountType) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.String() + `"`), nil
+}
+
+func (t *AccountType) UnmarshalJSON(b []byte) error {
+	for i, name := range accountTypeNames {
+		if string(b) == `"`+name+`"` {
+			*t = AccountType(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid account type: %s", string(b))
+}
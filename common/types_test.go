@@ -0,0 +1,25 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountType(t *testing.T) {
+	var types []AccountType
+	assert.Nil(t, json.Unmarshal([]byte(`["asset", "liability", "equity", "income", "expense"]`), &types))
+	assert.Equal(t, []AccountType{Asset, Liability, Equity, Income, Expense}, types)
+	assert.Equal(t, []Direction{Debit, Credit, Credit, Credit, Debit}, []Direction{
+		Asset.NormalBalance(), Liability.NormalBalance(), Equity.NormalBalance(), Income.NormalBalance(), Expense.NormalBalance(),
+	})
+	assert.Equal(t, Debit, Unclassified.NormalBalance())
+
+	b, err := json.Marshal(Liability)
+	assert.Nil(t, err)
+	assert.Equal(t, `"liability"`, string(b))
+
+	var accountType AccountType
+	assert.NotNil(t, json.Unmarshal([]byte(`"revenue"`), &accountType))
+}
@@ -1,23 +1,181 @@
 package core
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"ledger/common"
-	"math/big"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/rs/xid"
 )
 
 type EntryTemplate struct {
 	Key        string           `json:"key"`
 	AccountKey string           `json:"account"`
-	Amount     string           `json:"amount"` // This is a string because it appears to be a templated form
+	Amount     string           `json:"amount"` // expression over the transaction parameters, see expr.go
 	Direction  common.Direction `json:"direction"`
+	// Currency is the ISO 4217 code of the amount and may use template
+	// parameters. It defaults to the currency of the transaction template, then
+	// to that of the account, then to common.NoCurrency.
+	Currency string `json:"currency,omitempty"`
+	// Rounding names the common.RoundingMode applied when Amount has more
+	// decimal places than the currency allows. Without it such amounts are
+	// rejected.
+	Rounding string `json:"rounding,omitempty"`
+	// Condition is an expression over the transaction parameters; the line is
+	// only created when it is true. See expr.go.
+	Condition string `json:"condition,omitempty"`
+	// SkipIfZero drops the line when its amount evaluates to zero.
+	SkipIfZero bool `json:"skip_if_zero,omitempty"`
+	// Repeat names a list parameter. The line then creates one entry per row
+	// of the list, with the fields of the row available as {{.item.field}}, or
+	// under the name given by As instead of item.
+	Repeat string `json:"repeat,omitempty"`
+	As     string `json:"as,omitempty"`
+
+	amount          expr   // Amount, parsed
+	amountSource    string // the Amount that amount was parsed from
+	condition       expr   // Condition, parsed; nil without one
+	conditionSource string // the Condition that condition was parsed from
+}
+
+func (line *EntryTemplate) UnmarshalJSON(b []byte) error {
+	type entryTemplate EntryTemplate
+	if err := json.Unmarshal(b, (*entryTemplate)(line)); err != nil {
+		return err
+	}
+	// A line that doesn't compile is still loaded, so that ValidateTemplate
+	// can report it together with every other problem of the template.
+	line.compile()
+	return nil
+}
+
+// compile parses the amount, condition and rounding mode of line and keeps
+// the expressions on it. Templates registered with a ledger are compiled once,
+// when they are added.
+func (line *EntryTemplate) compile() error {
+	amount, condition, err := line.parse()
+	if err != nil {
+		return &LineError{Key: line.Key, Err: err}
+	}
+	line.amount, line.amountSource = amount, line.Amount
+	line.condition, line.conditionSource = condition, line.Condition
+	return nil
+}
+
+func (line *EntryTemplate) parse() (amount expr, condition expr, err error) {
+	if amount, err = parseExpr(line.Amount); err != nil {
+		return nil, nil, err
+	}
+	if line.Condition != "" {
+		if condition, err = parseExpr(line.Condition); err != nil {
+			return nil, nil, err
+		}
+	}
+	if line.Rounding != "" {
+		if _, err := common.ParseRoundingMode(line.Rounding); err != nil {
+			return nil, nil, err
+		}
+	}
+	return amount, condition, nil
+}
+
+// compiled returns the parsed expressions of line. They are parsed again,
+// without being kept, when the fields of line changed since it was compiled,
+// so that lines shared between goroutines are only read.
+func (line *EntryTemplate) compiled() (amount expr, condition expr, err error) {
+	if line.amount == nil || line.amountSource != line.Amount || line.conditionSource != line.Condition {
+		return line.parse()
+	}
+	return line.amount, line.condition, nil
+}
+
+// applies evaluates the condition of line.
+func (line *EntryTemplate) applies(params map[string]string) (bool, error) {
+	_, condition, err := line.compiled()
+	if err != nil {
+		return false, err
+	}
+	if condition == nil {
+		return true, nil
+	}
+	value, err := condition.eval(paramsEnv(params))
+	if err != nil {
+		return false, fmt.Errorf("condition: %w", err)
+	}
+	return value.Sign() != 0, nil
+}
+
+// evalAmount evaluates the amount of line as a non-negative amount of currency.
+func (line *EntryTemplate) evalAmount(params map[string]string, currency common.Currency) (common.Money, error) {
+	compiled, _, err := line.compiled()
+	if err != nil {
+		return common.Money{}, err
+	}
+	amount, err := compiled.eval(paramsEnv(params))
+	if err != nil {
+		return common.Money{}, err
+	}
+	if !amount.Exact(currency.Scale) {
+		if line.Rounding == "" {
+			return common.Money{}, fmt.Errorf("amount %s has more than %d decimal places for %s and the line has no rounding mode", amount, currency.Scale, currency)
+		}
+		mode, _ := common.ParseRoundingMode(line.Rounding)
+		amount = amount.Round(currency.Scale, mode)
+	}
+	if amount.Sign() < 0 {
+		return common.Money{}, fmt.Errorf("amount %s is negative", amount)
+	}
+	return common.MoneyFromDecimal(amount, currency)
+}
+
+// lineCurrency resolves the currency of line from the first of the line, the
+// transaction template and the account that names one.
+func (line *EntryTemplate) lineCurrency(params map[string]string, defaultCurrency string, account *Account) (common.Currency, error) {
+	code := line.Currency
+	if code == "" {
+		code = defaultCurrency
+	}
+	code, err := parseTemplateField(code, params)
+	if err != nil {
+		return common.Currency{}, err
+	}
+	if code == "" {
+		code = account.Currency
+	}
+	if code == "" {
+		code = common.NoCurrency
+	}
+	return common.LookupCurrency(strings.ToUpper(code))
+}
+
+// paramsEnv resolves expression parameters from the transaction parameters.
+func paramsEnv(params map[string]string) exprEnv {
+	return func(name string) (common.Decimal, error) {
+		value, exists := params[name]
+		if !exists {
+			return common.Decimal{}, fmt.Errorf("%w %s", errMissingParameter, name)
+		}
+		decimal, err := common.ParseDecimal(value)
+		if err != nil {
+			return common.Decimal{}, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		return decimal, nil
+	}
 }
 
 type TransactionTemplate struct {
-	Type                  string          `json:"type"`
+	Type string `json:"type"`
+	// Version is assigned by Ledger.AddTemplate, counting up from 1 for every
+	// change to a type.
+	Version               int             `json:"version,omitempty"`
+	Currency              string          `json:"currency,omitempty"` // default currency of the lines
+	Parameters            []ParamSpec     `json:"parameters,omitempty"`
 	LedgerEntriesTemplate []EntryTemplate `json:"lines"`
 }
 
@@ -29,147 +187,344 @@ type TransactionInput struct {
 	Type       string            `json:"type"`
 	Ledger     LedgerInfo        `json:"ledger"`
 	Parameters map[string]string `json:"parameters"`
+	// Lists holds the list parameters, one map of fields per row. In JSON
+	// they are given among the parameters as arrays of objects.
+	Lists   map[string][]map[string]string `json:"-"`
+	Pending bool                           `json:"pending,omitempty"` // entries are created as common.Pending instead of common.Posted
+	// EffectiveDate is the value date of the transaction, as 2006-01-02 or
+	// RFC 3339. The transaction is effective when it is posted without one.
+	EffectiveDate string `json:"effective_date,omitempty"`
+}
+
+func (input *TransactionInput) UnmarshalJSON(b []byte) error {
+	type transactionInput TransactionInput
+	raw := struct {
+		*transactionInput
+		Parameters map[string]json.RawMessage `json:"parameters"`
+	}{transactionInput: (*transactionInput)(input)}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	input.Parameters = make(map[string]string, len(raw.Parameters))
+	for name, value := range raw.Parameters {
+		var list []map[string]string
+		if len(value) > 0 && value[0] == '[' {
+			if err := json.Unmarshal(value, &list); err != nil {
+				return fmt.Errorf("parameter %s: %w", name, err)
+			}
+			if input.Lists == nil {
+				input.Lists = make(map[string][]map[string]string)
+			}
+			input.Lists[name] = list
+			continue
+		}
+		var scalar string
+		if err := json.Unmarshal(value, &scalar); err != nil {
+			return fmt.Errorf("parameter %s: %w", name, err)
+		}
+		input.Parameters[name] = scalar
+	}
+	return nil
+}
+
+// Hash fingerprints everything in input except its ledger info, so that a
+// retried input can be told apart from a different one reusing the key. The
+// template version is left out as well: a retry may pin the version the
+// first attempt resolved to, and Ledger.CreateTransaction compares it with
+// the version the transaction was created from instead.
+func (input TransactionInput) Hash() common.Hash {
+	fingerprint := struct {
+		Type       string                         `json:"type"`
+		Parameters map[string]string              `json:"parameters"`
+		Lists      map[string][]map[string]string `json:"lists,omitempty"`
+		Pending    bool                           `json:"pending"`
+		Effective  string                         `json:"effective_date,omitempty"`
+	}{input.Type, input.Parameters, input.Lists, input.Pending, input.EffectiveDate}
+	payload, _ := json.Marshal(fingerprint) // map keys are encoded in sorted order
+	return sha256.Sum256(payload)
+}
+
+// effectiveAt parses EffectiveDate, returning the zero time when it is empty.
+func (input TransactionInput) effectiveAt() (time.Time, error) {
+	if input.EffectiveDate == "" {
+		return time.Time{}, nil
+	}
+	if at, err := time.Parse(time.DateOnly, input.EffectiveDate); err == nil {
+		return at, nil
+	}
+	at, err := time.Parse(time.RFC3339, input.EffectiveDate)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid effective date %q", input.EffectiveDate)
+	}
+	return at, nil
 }
 
 // TODO: maybeMoved to transaction or ledger.go in future
 type LedgerInfo struct {
-	IK      string `json:"ik"`
+	IK string `json:"ik"`
+	// Version pins the template version the input is posted with. The latest
+	// version is used when it is empty.
 	Version string `json:"version,omitempty"` // `omitempty` will ignore the field if it's empty when encoding to JSON
 }
 
-func (line EntryTemplate) createEntry(params map[string]string) ([]Entries, error) {
-	// Use text/template to evaluate the amount string
-	tmpl, err := template.New("amountCalc").Parse(line.Amount)
-	if err != nil {
-		return nil, err
+// templateVersion parses Version, returning 0 for the latest version.
+func (info LedgerInfo) templateVersion() (int, error) {
+	if info.Version == "" {
+		return 0, nil
 	}
-
-	var builder strings.Builder
-	err = tmpl.Execute(&builder, params)
-	if err != nil {
-		return nil, err
+	version, err := strconv.Atoi(info.Version)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%w: invalid version %q", ErrTemplateNotFound, info.Version)
 	}
+	return version, nil
+}
 
-	parts := strings.Split(builder.String(), "+") // Splitting by '+'
-	total := big.NewInt(0)
-	for _, part := range parts {
-		amount := new(big.Int)
-		trimmedPart := strings.TrimSpace(part)
-		amount, _ = amount.SetString(trimmedPart, 10)
-		total = total.Add(total, amount)
-	}
+// lineContext is what the lines of a transaction template are rendered with.
+type lineContext struct {
+	store    Store
+	params   map[string]string
+	lists    map[string][]map[string]string
+	currency string // default currency of the lines
+	status   common.Status
+}
 
-	accountKey, err := parseTemplateField(line.AccountKey, params)
-	if err != nil {
-		return nil, err
+// createEntry renders line into its entries: none when its condition is
+// false, one per row of its list when it repeats, and one otherwise.
+func (line *EntryTemplate) createEntry(ctx lineContext) ([]Entries, error) {
+	if line.Repeat == "" {
+		entry, err := line.createOne(ctx, ctx.params)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+		entry.Key = line.Key
+		return []Entries{*entry}, nil
 	}
 
-	account, exists := AccountStore[accountKey]
+	rows, exists := ctx.lists[line.Repeat]
 	if !exists {
-		return nil, fmt.Errorf("Account with key %s not found", line.AccountKey)
+		return nil, fmt.Errorf("%w %s", errMissingParameter, line.Repeat)
+	}
+	var entries []Entries
+	for i, row := range rows {
+		entry, err := line.createOne(ctx, rowParams(ctx.params, line.alias(), row))
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", line.Repeat, i, err)
+		}
+		if entry != nil {
+			entry.Key = fmt.Sprintf("%s[%d]", line.Key, i)
+			entries = append(entries, *entry)
+		}
 	}
+	return entries, nil
+}
 
-	// Create Entries
-	entry := NewEntry(account, total, line.Direction, common.Posted) // Adjust `common.Debit` as per your need
+// alias is the name the fields of a repeated row are available under.
+func (line *EntryTemplate) alias() string {
+	if line.As != "" {
+		return line.As
+	}
+	return "item"
+}
 
-	return []Entries{*entry}, nil
+// rowParams returns params with the fields of row added as alias.field.
+func rowParams(params map[string]string, alias string, row map[string]string) map[string]string {
+	merged := make(map[string]string, len(params)+len(row))
+	for name, value := range params {
+		merged[name] = value
+	}
+	for field, value := range row {
+		merged[alias+"."+field] = value
+	}
+	return merged
 }
 
-func CreateTransaction(ik string, ledgerIK string, transactionType string, ledgerLines []EntryTemplate, params map[string]string) *Transaction {
-	//we are ignoring ledgerIk for now
+// createOne renders a single entry of line, or nil when the line is skipped.
+func (line *EntryTemplate) createOne(ctx lineContext, params map[string]string) (*Entries, error) {
+	applies, err := line.applies(params)
+	if err != nil || !applies {
+		return nil, err
+	}
 
-	entriesList := []Entries{}
+	accountKey, err := parseTemplateField(line.AccountKey, params)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, line := range ledgerLines {
-		entries, err := line.createEntry(params)
-		if err != nil {
-			fmt.Println("Error creating entries:", err)
-			continue
-		}
-		entriesList = append(entriesList, entries...)
+	account, err := ctx.store.Account(accountKey)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create a transaction with the entries
-	transaction := Transaction{
-		id:      ik,
-		entries: entriesList,
+	lineCurrency, err := line.lineCurrency(params, ctx.currency, account)
+	if err != nil {
+		return nil, err
+	}
+	total, err := line.evalAmount(params, lineCurrency)
+	if err != nil {
+		return nil, err
+	}
+	if line.SkipIfZero && total.Sign() == 0 {
+		return nil, nil
 	}
 
-	// Add the transaction to your ledger (omitting that part for brevity)
-	// ...
+	return NewEntry(account, total, line.Direction, ctx.status), nil
+}
 
-	// Printing for demo purposes
-	fmt.Println(transaction)
-	return &transaction
+// CreateTransaction builds a transaction from ledgerLines, resolving accounts
+// in store. Lines that cannot be rendered and transactions whose debits and
+// credits differ are rejected.
+func CreateTransaction(store Store, ik string, ledgerIK string, transactionType string, ledgerLines []EntryTemplate, params map[string]string) (*Transaction, error) {
+	//we are ignoring ledgerIk for now
+	return buildTransaction(lineContext{store: store, params: params, status: common.Posted}, ik, ledgerLines)
 }
 
-func (ledgertransaction TransactionTemplate) CreateTransaction(input TransactionInput) *Transaction {
-	ik := input.Ledger.IK
+// CreateTransaction builds the transaction described by input without posting
+// it. Inputs that don't fit the declared parameters are rejected with a
+// *ParamsError.
+func (ledgertransaction TransactionTemplate) CreateTransaction(store Store, input TransactionInput) (*Transaction, error) {
+	effectiveAt, err := input.effectiveAt()
+	if err != nil {
+		return nil, err
+	}
+	// Account parameters may name accounts that posting would create, but
+	// only the accounts of the entries are created: they are checked against
+	// a creator of their own.
+	params, lists, err := ledgertransaction.resolveParams(newAccountCreator(store, false), input.Parameters, input.Lists)
+	if err != nil {
+		return nil, err
+	}
+	ctx := lineContext{
+		store:    store,
+		params:   params,
+		lists:    lists,
+		currency: ledgertransaction.Currency,
+		status:   common.Posted,
+	}
+	if input.Pending {
+		ctx.status = common.Pending
+	}
+	transaction, err := buildTransaction(ctx, input.Ledger.IK, ledgertransaction.LedgerEntriesTemplate)
+	if err != nil {
+		return nil, err
+	}
+	transaction.template = TemplateRef{Type: ledgertransaction.Type, Version: ledgertransaction.Version}
+	transaction.effectiveAt = effectiveAt
+	return transaction, nil
+}
 
+func buildTransaction(ctx lineContext, id string, ledgerLines []EntryTemplate) (*Transaction, error) {
 	entriesList := []Entries{}
+	var errs []error
 
-	for _, line := range ledgertransaction.LedgerEntriesTemplate {
-		entries, err := line.createEntry(input.Parameters)
+	for i := range ledgerLines {
+		line := &ledgerLines[i]
+		entries, err := line.createEntry(ctx)
 		if err != nil {
-			fmt.Println("Error creating entries:", err)
+			errs = append(errs, &LineError{Key: line.Key, Err: err})
 			continue
 		}
 		entriesList = append(entriesList, entries...)
 	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(entriesList) == 0 {
+		return nil, ErrEmptyTransaction
+	}
 
-	// Create a transaction with the entries
-	transaction := Transaction{
-		id:      ik,
+	if id == "" {
+		id = xid.New().String()
+	}
+	transaction := &Transaction{
+		id:      id,
 		entries: entriesList,
 	}
-
-	// Add the transaction to your ledger (omitting that part for brevity)
-	// ...
-
-	// Printing for demo purposes
-	fmt.Println(transaction)
-	return &transaction
+	if err := transaction.Balanced(); err != nil {
+		return nil, err
+	}
+	return transaction, nil
 }
 
+// AccountTemplate describes an account of the chart of accounts. Children
+// are nested to any depth, and their keys are relative to their parent: the
+// child "bank" of "assets" is the account "assets/bank". A child without a
+// name or currency can be given as just its key.
+//
+// A key with placeholders, like "users/{{id}}/wallet", makes the entry a
+// template for accounts that are created on demand, see account_template.go.
 type AccountTemplate struct {
-	Key       string   `json:"key"`
-	Name      string   `json:"name,omitempty"`
-	Childrens []string `json:"children,omitempty"`
-	template  bool     `json:"template,omitempty"`
+	Key      string             `json:"key"`
+	Name     string             `json:"name,omitempty"`
+	Type     common.AccountType `json:"type,omitempty"`     // inherited by children that don't set their own
+	Currency string             `json:"currency,omitempty"` // inherited by children that don't set their own
+	// Parent is the key of an existing account, or of another account of the
+	// chart, that the account is created under. Its key is kept as is.
+	Parent    string             `json:"parent,omitempty"`
+	Childrens []*AccountTemplate `json:"children,omitempty"`
+	// Constraints bound the balances of the account, but not of its children.
+	Constraints []BalanceConstraint `json:"constraints,omitempty"`
+	// AutoCreate lets a templated account be created when a transaction first
+	// uses it. Other templated accounts are only created by
+	// Ledger.MaterializeAccount.
+	AutoCreate bool `json:"auto_create,omitempty"`
+	// Patterns restricts the values of the placeholders of a templated key
+	// with regular expressions, such as {"id": "[0-9]+"}. A placeholder
+	// without a pattern matches one segment of the key.
+	Patterns map[string]string `json:"patterns,omitempty"`
+}
+
+func (accountType *AccountTemplate) UnmarshalJSON(b []byte) error {
+	var key string
+	if err := json.Unmarshal(b, &key); err == nil {
+		*accountType = AccountTemplate{Key: key}
+		return nil
+	}
+	type accountTemplate AccountTemplate
+	return json.Unmarshal(b, (*accountTemplate)(accountType))
 }
 
 type ChartOfAccounts struct {
 	Accounts []*AccountTemplate `json:"accounts"`
 }
 
+// CreateAccount builds the account described by accountType together with
+// its descendants. Use Ledger.CreateAccount to also store them.
 func (accountType *AccountTemplate) CreateAccount() *Account {
-	childrensAccount := make([]Account, len(accountType.Childrens))
+	return accountType.createAccount(accountType.Key, accountType.Parent, common.Unclassified, accountType.Currency)
+}
 
-	for i, children := range accountType.Childrens {
-		fullKey := fmt.Sprintf("%s/%s", accountType.Key, children)
-		childrensAccount[i] = Account{Key: fullKey}
-		AccountStore[fullKey] = &childrensAccount[i]
+func (accountType *AccountTemplate) createAccount(key string, parent string, kind common.AccountType, currency string) *Account {
+	if accountType.Type != common.Unclassified {
+		kind = accountType.Type
+	}
+	if accountType.Currency != "" {
+		currency = accountType.Currency
 	}
-	fmt.Printf("size of AccountStore %v", len(AccountStore))
-
 	account := &Account{
-		Key:      accountType.Key,
-		Name:     accountType.Name,
-		Children: childrensAccount,
+		Key:         key,
+		Name:        accountType.Name,
+		Type:        kind,
+		Currency:    currency,
+		Parent:      parent,
+		Children:    make([]Account, len(accountType.Childrens)),
+		Constraints: accountType.Constraints,
+	}
+	for i, child := range accountType.Childrens {
+		fullKey := fmt.Sprintf("%s/%s", key, child.Key)
+		account.Children[i] = *child.createAccount(fullKey, key, kind, currency)
 	}
-	AccountStore[account.Key] = account
 	return account
-
 }
 
 func parseTemplateField(templateStr string, params map[string]string) (string, error) {
-	tmpl, err := template.New("templateField").Parse(templateStr)
+	tmpl, err := template.New("templateField").Option("missingkey=error").Parse(templateStr)
 	if err != nil {
 		return "", err
 	}
 
 	var builder strings.Builder
-	err = tmpl.Execute(&builder, params)
+	err = tmpl.Execute(&builder, templateData(params))
 	if err != nil {
 		return "", err
 	}
@@ -177,6 +532,26 @@ func parseTemplateField(templateStr string, params map[string]string) (string, e
 	return builder.String(), nil
 }
 
+// templateData nests dotted parameter names, so that the parameter
+// item.merchant can be written as {{.item.merchant}}.
+func templateData(params map[string]string) map[string]any {
+	data := make(map[string]any, len(params))
+	for name, value := range params {
+		parts := strings.Split(name, ".")
+		node := data
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				node[part] = child
+			}
+			node = child
+		}
+		node[parts[len(parts)-1]] = value
+	}
+	return data
+}
+
 func UnmarshalLedgerEntryTemplate(ledgerEntryTemplateJson []byte) (*EntryTemplate, error) {
 	ledgerEntryTemplate := &EntryTemplate{}
 	err := json.Unmarshal([]byte(ledgerEntryTemplateJson), ledgerEntryTemplate)
@@ -189,8 +564,8 @@ func UnmarshalLedgerTransactionTemplate(ledgerTransactionTemplateJson []byte) (*
 	return ledgerTransactionTemplate, err
 }
 
-func UnmarshalLedgerTransactionListTemplate(TransactionsListTemplateJson []byte) (*TransactionTemplate, error) {
-	TransactionsListTemplate := &TransactionTemplate{}
+func UnmarshalLedgerTransactionListTemplate(TransactionsListTemplateJson []byte) (*TransactionsListTemplate, error) {
+	TransactionsListTemplate := &TransactionsListTemplate{}
 	err := json.Unmarshal([]byte(TransactionsListTemplateJson), TransactionsListTemplate)
 	return TransactionsListTemplate, err
 }
@@ -1,16 +1,20 @@
 package core
 
+import "ledger/common"
+
 const (
 	AddressLength = 32
 )
 
 type Account struct {
-	Key      string    `json:"key"`
-	Name     string    `json:"name,omitempty"`
+	Key      string             `json:"key"`
+	Name     string             `json:"name,omitempty"`
+	Type     common.AccountType `json:"type,omitempty"`     // decides the normal side of the balance, shared by children
+	Currency string             `json:"currency,omitempty"` // when set, only entries in this currency can be posted
+	Parent   string             `json:"parent,omitempty"`   // key of the parent account, empty for a root
+	// Children are the accounts created together with this one. Stored
+	// accounts leave them out, Ledger.Children lists them instead.
 	Children []Account `json:"children,omitempty"`
+	// Constraints are checked whenever a transaction is posted to the account.
+	Constraints []BalanceConstraint `json:"constraints,omitempty"`
 }
-
-// Persistent Layer -------------------------------->
-type AccountsStore map[string]*Account
-
-var AccountStore = make(AccountsStore)
@@ -0,0 +1,264 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches the placeholders of templated account keys,
+// written {{name}} or {{.name}}.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*\.?([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Templated reports whether the key of accountType has placeholders.
+func (accountType *AccountTemplate) Templated() bool {
+	return strings.Contains(accountType.Key, "{{")
+}
+
+// placeholders returns the names of the placeholders in text.
+func placeholders(text string) []string {
+	var names []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(text, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// keys returns the templated keys of accountType and its descendants.
+func (accountType *AccountTemplate) keys() []string {
+	keys := []string{accountType.Key}
+	var walk func(prefix string, children []*AccountTemplate)
+	walk = func(prefix string, children []*AccountTemplate) {
+		for _, child := range children {
+			key := prefix + "/" + child.Key
+			keys = append(keys, key)
+			walk(key, child.Childrens)
+		}
+	}
+	walk(accountType.Key, accountType.Childrens)
+	return keys
+}
+
+// keyPattern compiles a templated key into a regular expression matching the
+// keys it stands for.
+func (accountType *AccountTemplate) keyPattern(key string) (*regexp.Regexp, []string, error) {
+	var pattern strings.Builder
+	var names []string
+	pattern.WriteString("^")
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(key, -1) {
+		name := key[match[2]:match[3]]
+		valuePattern, exists := accountType.Patterns[name]
+		if !exists {
+			valuePattern = "[^/]+"
+		}
+		pattern.WriteString(regexp.QuoteMeta(key[last:match[0]]))
+		fmt.Fprintf(&pattern, "(?P<p%d>%s)", len(names), valuePattern)
+		names = append(names, name)
+		last = match[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(key[last:]))
+	pattern.WriteString("$")
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("account template %s: %w", accountType.Key, err)
+	}
+	return re, names, nil
+}
+
+// match returns the placeholder values for which accountType, or one of its
+// descendants, has the given key.
+func (accountType *AccountTemplate) match(key string) (map[string]string, bool) {
+	for _, templated := range accountType.keys() {
+		re, names, err := accountType.keyPattern(templated)
+		if err != nil {
+			return nil, false
+		}
+		submatches := re.FindStringSubmatch(key)
+		if submatches == nil {
+			continue
+		}
+		values := make(map[string]string, len(names))
+		consistent := true
+		for i, name := range names {
+			value := submatches[re.SubexpIndex(fmt.Sprintf("p%d", i))]
+			if previous, exists := values[name]; exists && previous != value {
+				consistent = false
+			}
+			values[name] = value
+		}
+		if consistent {
+			return values, true
+		}
+	}
+	return nil, false
+}
+
+// instantiate returns the account template accountType stands for with the
+// given placeholder values.
+func (accountType *AccountTemplate) instantiate(values map[string]string) *AccountTemplate {
+	render := func(text string) string {
+		return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+			return values[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+		})
+	}
+	var instantiate func(accountType *AccountTemplate) *AccountTemplate
+	instantiate = func(accountType *AccountTemplate) *AccountTemplate {
+		concrete := &AccountTemplate{
+			Key:         render(accountType.Key),
+			Name:        render(accountType.Name),
+			Type:        accountType.Type,
+			Currency:    render(accountType.Currency),
+			Parent:      render(accountType.Parent),
+			Childrens:   make([]*AccountTemplate, len(accountType.Childrens)),
+			Constraints: accountType.Constraints,
+		}
+		for i, child := range accountType.Childrens {
+			concrete.Childrens[i] = instantiate(child)
+		}
+		return concrete
+	}
+	return instantiate(accountType)
+}
+
+// validate reports the problems of a templated account: patterns that don't
+// compile or name no placeholder, and placeholders that the key does not
+// bind.
+func (accountType *AccountTemplate) validate() []error {
+	var errs []error
+	bound := make(map[string]bool)
+	for _, name := range placeholders(accountType.Key) {
+		bound[name] = true
+	}
+	for name := range accountType.Patterns {
+		if !bound[name] {
+			errs = append(errs, fmt.Errorf("account template %s: pattern for unknown placeholder %s", accountType.Key, name))
+		}
+	}
+	for _, key := range accountType.keys() {
+		if _, _, err := accountType.keyPattern(key); err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+
+	var check func(node *AccountTemplate, texts ...string)
+	check = func(node *AccountTemplate, texts ...string) {
+		for _, text := range append(texts, node.Name, node.Currency) {
+			for _, name := range placeholders(text) {
+				if !bound[name] {
+					errs = append(errs, fmt.Errorf("account template %s: placeholder %s is not part of the key", accountType.Key, name))
+				}
+			}
+		}
+		for _, constraint := range node.Constraints {
+			if err := constraint.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("account template %s: %w", accountType.Key, err))
+			}
+		}
+		for _, child := range node.Childrens {
+			check(child, child.Key)
+		}
+	}
+	check(accountType, accountType.Parent)
+	return errs
+}
+
+// plannedAccounts is a Store that also finds the accounts planned to be
+// created, before they are stored.
+type plannedAccounts struct {
+	Store
+	accounts map[string]*Account
+	order    []*Account // top-level planned accounts, parents first
+}
+
+func (p *plannedAccounts) Account(key string) (*Account, error) {
+	if account, exists := p.accounts[key]; exists {
+		return account, nil
+	}
+	return p.Store.Account(key)
+}
+
+func (p *plannedAccounts) add(account *Account) {
+	if p.accounts == nil {
+		p.accounts = make(map[string]*Account)
+	}
+	p.order = append(p.order, account)
+	var walk func(account *Account)
+	walk = func(account *Account) {
+		p.accounts[account.Key] = account
+		for i := range account.Children {
+			walk(&account.Children[i])
+		}
+	}
+	walk(account)
+}
+
+// needed returns the top-level planned accounts that hold one of keys or an
+// ancestor of one, parents first.
+func (p *plannedAccounts) needed(keys []string) []*Account {
+	marked := make(map[string]bool)
+	for _, key := range keys {
+		for account, exists := p.accounts[key]; exists && !marked[account.Key]; account, exists = p.accounts[account.Parent] {
+			marked[account.Key] = true
+		}
+	}
+	var accounts []*Account
+	for _, account := range p.order {
+		if marked[account.Key] {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// accountCreator is a Store that plans accounts from the account templates
+// of the store when they are first looked up. Ledger.storeAccounts stores them
+// once they are known to be needed.
+type accountCreator struct {
+	*plannedAccounts
+	explicit bool // also use templates without AutoCreate
+}
+
+func newAccountCreator(store Store, explicit bool) *accountCreator {
+	// A creator on top of another one sees what that one planned, but plans
+	// on its own.
+	if creator, ok := store.(*accountCreator); ok {
+		store = creator.plannedAccounts
+	}
+	return &accountCreator{plannedAccounts: &plannedAccounts{Store: store}, explicit: explicit}
+}
+
+func (c *accountCreator) Account(key string) (*Account, error) {
+	account, err := c.plannedAccounts.Account(key)
+	if !errors.Is(err, ErrAccountNotFound) {
+		return account, err
+	}
+	accountTypes, templatesErr := c.AccountTemplates()
+	if templatesErr != nil {
+		return nil, templatesErr
+	}
+	for _, accountType := range accountTypes {
+		if !accountType.AutoCreate && !c.explicit {
+			continue
+		}
+		values, matches := accountType.match(key)
+		if !matches {
+			continue
+		}
+		concrete := accountType.instantiate(values)
+		if concrete.Parent != "" {
+			if _, err := c.Account(concrete.Parent); err != nil {
+				return nil, fmt.Errorf("account %s: parent: %w", concrete.Key, err)
+			}
+		}
+		accounts, err := planAccounts(c.plannedAccounts, []*AccountTemplate{concrete})
+		if err != nil {
+			return nil, err
+		}
+		c.add(accounts[0])
+		return c.plannedAccounts.Account(key)
+	}
+	return nil, err
+}
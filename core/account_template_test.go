@@ -0,0 +1,207 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"ledger/common"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const templatedChartJson = `{
+	"accounts": [
+		{"key": "assets", "type": "asset", "children": ["bank"]},
+		{"key": "liabilities", "type": "liability", "children": ["users"]},
+		{
+			"key": "liabilities/users/{{id}}",
+			"parent": "liabilities/users",
+			"name": "User {{id}}",
+			"children": ["wallet", "hold"],
+			"auto_create": true,
+			"patterns": {"id": "[0-9]+"}
+		},
+		{"key": "merchants/{{name}}", "parent": "liabilities", "currency": "USD"}
+	]
+}`
+
+const topUpTemplateJson = `{
+	"type": "top_up",
+	"currency": "USD",
+	"lines": [
+		{"key": "bank", "account": "assets/bank", "amount": "{{.amount}}", "direction": "Debit"},
+		{"key": "wallet", "account": "liabilities/users/{{.user}}/wallet", "amount": "{{.amount}}", "direction": "Credit"}
+	]
+}`
+
+func loadTemplatedChart(t *testing.T, ledger *Ledger) {
+	chartOfAccounts := &ChartOfAccounts{}
+	assert.Nil(t, json.Unmarshal([]byte(templatedChartJson), chartOfAccounts))
+	assert.Nil(t, ledger.LoadChart(chartOfAccounts))
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(topUpTemplateJson))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+}
+
+func TestAutoCreatedAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	loadTemplatedChart(t, ledger)
+
+	_, err := ledger.Account("liabilities/users/42")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "top_up",
+		Ledger:     LedgerInfo{IK: "top-up-1"},
+		Parameters: map[string]string{"user": "42", "amount": "25.00"},
+	})
+	assert.Nil(t, err)
+
+	user, err := ledger.Account("liabilities/users/42")
+	assert.Nil(t, err)
+	assert.Equal(t, "User 42", user.Name)
+	assert.Equal(t, "liabilities/users", user.Parent)
+	children, err := ledger.Children(user.Key)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(children))
+	children, err = ledger.Children("liabilities/users")
+	assert.Nil(t, err)
+	assert.Equal(t, "liabilities/users/42", children[0].Key)
+
+	balance, err := ledger.RollupBalance("liabilities")
+	assert.Nil(t, err)
+	assert.Equal(t, "25.00 USD", balance.Posted.String())
+
+	// A second posting uses the account that is now there.
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "top_up",
+		Ledger:     LedgerInfo{IK: "top-up-2"},
+		Parameters: map[string]string{"user": "42", "amount": "5.00"},
+	})
+	assert.Nil(t, err)
+
+	// Keys outside the patterns are not created, and neither is anything else
+	// of a rejected transaction.
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "top_up",
+		Parameters: map[string]string{"user": "bob", "amount": "5.00"},
+	})
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "top_up",
+		Parameters: map[string]string{"user": "7", "amount": "-5.00"},
+	})
+	assert.NotNil(t, err)
+	_, err = ledger.Account("liabilities/users/7")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+
+	ledger = reopen(t, ledger, path)
+	wallet, err := ledger.Account("liabilities/users/42/wallet")
+	assert.Nil(t, err)
+	balance, err = ledger.Balance(wallet.Key)
+	assert.Nil(t, err)
+	assert.Equal(t, "30.00 USD", balance.Posted.String())
+	templates, err := ledger.Store().AccountTemplates()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(templates))
+}
+
+func TestAccountParameterCreatesNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	loadTemplatedChart(t, ledger)
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(`{
+		"type": "top_up_for",
+		"currency": "USD",
+		"parameters": [
+			{"name": "amount", "type": "amount", "required": true},
+			{"name": "beneficiary", "type": "account", "required": true}
+		],
+		"lines": [
+			{"key": "bank", "account": "assets/bank", "amount": "{{.amount}}", "direction": "Debit"},
+			{"key": "wallet", "account": "liabilities/users/42/wallet", "amount": "{{.amount}}", "direction": "Credit"}
+		]
+	}`))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+
+	// The beneficiary could be auto-created, so it is a valid parameter, but
+	// only the accounts the entries are posted to get created.
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "top_up_for",
+		Parameters: map[string]string{"amount": "5.00", "beneficiary": "liabilities/users/7/hold"},
+	})
+	assert.Nil(t, err)
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "top_up_for",
+		Parameters: map[string]string{"amount": "5.00", "beneficiary": "liabilities/users/x"},
+	})
+	var paramsErr *ParamsError
+	assert.True(t, errors.As(err, &paramsErr))
+
+	for _, ledger := range []*Ledger{ledger, reopen(t, ledger, path)} {
+		_, err = ledger.Account("liabilities/users/42/wallet")
+		assert.Nil(t, err)
+		for _, key := range []string{"liabilities/users/7", "liabilities/users/7/hold"} {
+			_, err = ledger.Account(key)
+			assert.True(t, errors.Is(err, ErrAccountNotFound))
+		}
+	}
+}
+
+func TestMaterializeAccount(t *testing.T) {
+	ledger := newTestLedger(t)
+	loadTemplatedChart(t, ledger)
+
+	// Templates without auto_create are only materialized explicitly.
+	bank, err := ledger.Account("assets/bank")
+	assert.Nil(t, err)
+	err = ledger.Post(NewTransaction(
+		*NewEntry(bank, money(t, "1.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(&Account{Key: "merchants/acme"}, money(t, "1.00", "USD"), common.Credit, common.Posted),
+	))
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+
+	merchant, err := ledger.MaterializeAccount("merchants/acme")
+	assert.Nil(t, err)
+	assert.Equal(t, "USD", merchant.Currency)
+	assert.Equal(t, "liabilities", merchant.Parent)
+	again, err := ledger.MaterializeAccount("merchants/acme")
+	assert.Nil(t, err)
+	assert.Equal(t, merchant, again)
+
+	// Asking for a child creates the whole templated account.
+	_, err = ledger.MaterializeAccount("liabilities/users/9/hold")
+	assert.Nil(t, err)
+	_, err = ledger.Account("liabilities/users/9/wallet")
+	assert.Nil(t, err)
+
+	_, err = ledger.MaterializeAccount("merchants/acme/extra")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+}
+
+func TestInvalidAccountTemplates(t *testing.T) {
+	ledger := newTestLedger(t)
+	loadTemplatedChart(t, ledger)
+
+	err := ledger.AddAccountTemplate(&AccountTemplate{
+		Key:      "cards/{{id}}",
+		Name:     "{{owner}}",
+		Parent:   "missing",
+		Patterns: map[string]string{"id": "[", "other": "x"},
+	})
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+	assert.Contains(t, err.Error(), "placeholder owner is not part of the key")
+	assert.Contains(t, err.Error(), "pattern for unknown placeholder other")
+	assert.Contains(t, err.Error(), "missing closing ]")
+
+	err = ledger.AddAccountTemplate(&AccountTemplate{Key: "merchants/{{name}}"})
+	assert.True(t, errors.Is(err, ErrDuplicateAccount))
+
+	_, err = ledger.CreateAccount(&AccountTemplate{Key: "cards/{{id}}"})
+	assert.NotNil(t, err)
+	_, err = ledger.CreateAccount(&AccountTemplate{Key: "cards", Childrens: []*AccountTemplate{{Key: "{{id}}"}}})
+	assert.NotNil(t, err)
+}
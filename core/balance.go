@@ -0,0 +1,205 @@
+package core
+
+import (
+	"ledger/common"
+	"math/big"
+	"math/rand"
+	"time"
+)
+
+// Balance is the state of an account in one currency, derived from the
+// entries posted to it. Amounts on the normal side of the account type
+// increase the balance, and the other side decreases it.
+//
+// Posted counts only common.Posted entries. Pending additionally counts
+// common.Pending entries. Available is the posted balance less any pending
+// entries that would reduce it, so funds on their way out are held while funds
+// on their way in are not yet spendable.
+type Balance struct {
+	Posted    common.Money
+	Pending   common.Money
+	Available common.Money
+}
+
+// accountBalance keeps the running debit and credit totals of an account in
+// one currency.
+type accountBalance struct {
+	currency      common.Currency
+	postedDebit   *big.Int
+	postedCredit  *big.Int
+	pendingDebit  *big.Int
+	pendingCredit *big.Int
+}
+
+func newAccountBalance(currency common.Currency) *accountBalance {
+	return &accountBalance{
+		currency:      currency,
+		postedDebit:   big.NewInt(0),
+		postedCredit:  big.NewInt(0),
+		pendingDebit:  big.NewInt(0),
+		pendingCredit: big.NewInt(0),
+	}
+}
+
+func (b *accountBalance) clone() *accountBalance {
+	return &accountBalance{
+		currency:      b.currency,
+		postedDebit:   new(big.Int).Set(b.postedDebit),
+		postedCredit:  new(big.Int).Set(b.postedCredit),
+		pendingDebit:  new(big.Int).Set(b.pendingDebit),
+		pendingCredit: new(big.Int).Set(b.pendingCredit),
+	}
+}
+
+// add adds the totals of other to b.
+func (b *accountBalance) add(other *accountBalance) {
+	b.postedDebit.Add(b.postedDebit, other.postedDebit)
+	b.postedCredit.Add(b.postedCredit, other.postedCredit)
+	b.pendingDebit.Add(b.pendingDebit, other.pendingDebit)
+	b.pendingCredit.Add(b.pendingCredit, other.pendingCredit)
+}
+
+// since returns the totals b added after other.
+func (b *accountBalance) since(other *accountBalance) *accountBalance {
+	return &accountBalance{
+		currency:      b.currency,
+		postedDebit:   new(big.Int).Sub(b.postedDebit, other.postedDebit),
+		postedCredit:  new(big.Int).Sub(b.postedCredit, other.postedCredit),
+		pendingDebit:  new(big.Int).Sub(b.pendingDebit, other.pendingDebit),
+		pendingCredit: new(big.Int).Sub(b.pendingCredit, other.pendingCredit),
+	}
+}
+
+func (b *accountBalance) apply(entry Entries) {
+	if entry.Amount.Units == nil {
+		return
+	}
+	direction, amount := entry.Direction, entry.Amount.Units
+	if entry.Status == common.Pending && entry.cancels {
+		// A cancellation comes off the side the pending entry it reverses was
+		// held on, so that it no longer holds funds.
+		direction, amount = direction.Opposite(), new(big.Int).Neg(amount)
+	}
+	var total *big.Int
+	switch {
+	case entry.Status == common.Posted && direction == common.Debit:
+		total = b.postedDebit
+	case entry.Status == common.Posted && direction == common.Credit:
+		total = b.postedCredit
+	case entry.Status == common.Pending && direction == common.Debit:
+		total = b.pendingDebit
+	default:
+		total = b.pendingCredit
+	}
+	total.Add(total, amount)
+}
+
+// balance reports the totals with entries on the normal side increasing the
+// balance.
+func (b *accountBalance) balance(normal common.Direction) Balance {
+	increase, decrease := b.postedDebit, b.postedCredit
+	pendingIncrease, pendingDecrease := b.pendingDebit, b.pendingCredit
+	if normal == common.Credit {
+		increase, decrease = decrease, increase
+		pendingIncrease, pendingDecrease = pendingDecrease, pendingIncrease
+	}
+	posted := new(big.Int).Sub(increase, decrease)
+	pending := new(big.Int).Add(posted, pendingIncrease)
+	pending.Sub(pending, pendingDecrease)
+	available := new(big.Int).Sub(posted, pendingDecrease)
+	return Balance{
+		Posted:    common.Money{Units: posted, Currency: b.currency},
+		Pending:   common.Money{Units: pending, Currency: b.currency},
+		Available: common.Money{Units: available, Currency: b.currency},
+	}
+}
+
+// balanceHistory indexes the totals of an account in one currency by
+// effective time. The points form a treap ordered by time, and every node
+// keeps the totals of its subtree, so that posting at any time, backdated or
+// not, and the balance as of any time both take O(log n).
+type balanceHistory struct {
+	currency common.Currency
+	root     *balanceNode
+}
+
+type balanceNode struct {
+	at          time.Time
+	priority    uint32
+	delta       *accountBalance // totals of the entries effective at at
+	sum         *accountBalance // totals of the entries effective in the subtree
+	left, right *balanceNode
+}
+
+func (h *balanceHistory) apply(at time.Time, entry Entries) {
+	h.root = h.insert(h.root, at, entry)
+}
+
+// insert adds entry to the point at at below node, creating the point when
+// it is missing, and returns the new root of the subtree.
+func (h *balanceHistory) insert(node *balanceNode, at time.Time, entry Entries) *balanceNode {
+	if node == nil {
+		node = &balanceNode{
+			at:       at,
+			priority: rand.Uint32(),
+			delta:    newAccountBalance(h.currency),
+			sum:      newAccountBalance(h.currency),
+		}
+		node.delta.apply(entry)
+		node.sum.apply(entry)
+		return node
+	}
+	node.sum.apply(entry)
+	switch {
+	case at.Equal(node.at):
+		node.delta.apply(entry)
+	case at.Before(node.at):
+		node.left = h.insert(node.left, at, entry)
+		if node.left.priority > node.priority {
+			node = h.rotate(node, node.left)
+		}
+	default:
+		node.right = h.insert(node.right, at, entry)
+		if node.right.priority > node.priority {
+			node = h.rotate(node, node.right)
+		}
+	}
+	return node
+}
+
+// rotate lifts child above its parent node and returns it.
+func (h *balanceHistory) rotate(node, child *balanceNode) *balanceNode {
+	if child == node.left {
+		node.left, child.right = child.right, node
+	} else {
+		node.right, child.left = child.left, node
+	}
+	child.sum = node.sum
+	node.sum = node.delta.clone()
+	for _, subtree := range []*balanceNode{node.left, node.right} {
+		if subtree != nil {
+			node.sum.add(subtree.sum)
+		}
+	}
+	return child
+}
+
+// asOf returns the totals of the entries effective at or before at, and
+// whether there are any.
+func (h *balanceHistory) asOf(at time.Time) (*accountBalance, bool) {
+	total := newAccountBalance(h.currency)
+	var exists bool
+	for node := h.root; node != nil; {
+		if node.at.After(at) {
+			node = node.left
+			continue
+		}
+		exists = true
+		if node.left != nil {
+			total.add(node.left.sum)
+		}
+		total.add(node.delta)
+		node = node.right
+	}
+	return total, exists
+}
@@ -0,0 +1,394 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ledger/common"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLedger(t *testing.T, keys ...string) *Ledger {
+	ledger, err := NewLedger(NewMemoryStore())
+	assert.Nil(t, err)
+	for _, key := range keys {
+		_, err := ledger.CreateAccount(&AccountTemplate{Key: key})
+		assert.Nil(t, err)
+	}
+	return ledger
+}
+
+func money(t *testing.T, amount string, code string) common.Money {
+	m, err := common.ParseMoney(amount, code)
+	assert.Nil(t, err)
+	return m
+}
+
+// date is midnight UTC of a day in 2024.
+func date(month time.Month, d int) time.Time {
+	return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+// postAt posts transactionAt(t, ledger, effective, debit, credit, code,
+// amounts...) and returns it.
+func postAt(t *testing.T, ledger *Ledger, effective time.Time, debit string, credit string, code string, amounts ...string) *Transaction {
+	transaction := transactionAt(t, ledger, effective, debit, credit, code, amounts...)
+	assert.Nil(t, ledger.Post(transaction))
+	return transaction
+}
+
+// transactionAt builds a transaction effective at effective that debits each
+// of amounts to debit and credits their sum to credit. A zero effective time
+// leaves it to the ledger.
+func transactionAt(t *testing.T, ledger *Ledger, effective time.Time, debit string, credit string, code string, amounts ...string) *Transaction {
+	debitAccount, _ := ledger.Account(debit)
+	creditAccount, _ := ledger.Account(credit)
+	var entries []Entries
+	total := money(t, "0", code)
+	for _, amount := range amounts {
+		entries = append(entries, *NewEntry(debitAccount, money(t, amount, code), common.Debit, common.Posted))
+		total, _ = total.Add(money(t, amount, code))
+	}
+	entries = append(entries, *NewEntry(creditAccount, total, common.Credit, common.Posted))
+	transaction := NewTransaction(entries...)
+	transaction.SetEffectiveAt(effective)
+	return transaction
+}
+
+func TestLedgerBalance(t *testing.T) {
+	ledger := newTestLedger(t, "cash", "wallet")
+	cash, _ := ledger.Account("cash")
+	wallet, _ := ledger.Account("wallet")
+
+	deposit := NewTransaction(
+		*NewEntry(cash, money(t, "1000.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(wallet, money(t, "1000.00", "USD"), common.Credit, common.Posted),
+	)
+	assert.Nil(t, ledger.Post(deposit))
+
+	withdrawal := NewTransaction(
+		*NewEntry(cash, money(t, "300.00", "USD"), common.Credit, common.Pending),
+		*NewEntry(wallet, money(t, "300.00", "USD"), common.Debit, common.Pending),
+	)
+	assert.Nil(t, ledger.Post(withdrawal))
+
+	balance, err := ledger.Balance("cash")
+	assert.Nil(t, err)
+	assert.Equal(t, "1000.00 USD", balance.Posted.String())
+	assert.Equal(t, "700.00 USD", balance.Pending.String())
+	assert.Equal(t, "700.00 USD", balance.Available.String())
+
+	balance, err = ledger.Balance("wallet")
+	assert.Nil(t, err)
+	assert.Equal(t, "-1000.00 USD", balance.Posted.String())
+	assert.Equal(t, "-700.00 USD", balance.Pending.String())
+	assert.Equal(t, "-1000.00 USD", balance.Available.String())
+}
+
+func TestLedgerRejectsUnbalancedPost(t *testing.T) {
+	ledger := newTestLedger(t, "cash")
+	cash, _ := ledger.Account("cash")
+
+	err := ledger.Post(NewTransaction(*NewEntry(cash, money(t, "10.00", "USD"), common.Debit, common.Posted)))
+	var unbalanced *UnbalancedError
+	assert.True(t, errors.As(err, &unbalanced))
+
+	balance, err := ledger.Balance("cash")
+	assert.Nil(t, err)
+	assert.Equal(t, "0 XXX", balance.Posted.String())
+
+	_, err = ledger.Balance("missing")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+}
+
+func TestLedgerBalancePerCurrency(t *testing.T) {
+	ledger := newTestLedger(t, "cash", "fx")
+	_, err := ledger.CreateAccount(&AccountTemplate{Key: "wallet-usd", Currency: "USD"})
+	assert.Nil(t, err)
+	cash, _ := ledger.Account("cash")
+	fx, _ := ledger.Account("fx")
+	wallet, _ := ledger.Account("wallet-usd")
+
+	// Each currency balances on its own, even with equal numbers of units.
+	err = ledger.Post(NewTransaction(
+		*NewEntry(cash, money(t, "10.50", "USD"), common.Debit, common.Posted),
+		*NewEntry(fx, money(t, "1050", "JPY"), common.Credit, common.Posted),
+	))
+	var unbalanced *UnbalancedError
+	assert.True(t, errors.As(err, &unbalanced))
+	assert.Equal(t, "USD", unbalanced.Debit.Currency.Code)
+
+	assert.Nil(t, ledger.Post(NewTransaction(
+		*NewEntry(cash, money(t, "10.50", "USD"), common.Debit, common.Posted),
+		*NewEntry(fx, money(t, "10.50", "USD"), common.Credit, common.Posted),
+		*NewEntry(cash, money(t, "1500", "JPY"), common.Debit, common.Posted),
+		*NewEntry(fx, money(t, "1500", "JPY"), common.Credit, common.Posted),
+	)))
+
+	_, err = ledger.Balance("cash")
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+	balance, err := ledger.BalanceIn("cash", "JPY")
+	assert.Nil(t, err)
+	assert.Equal(t, "1500 JPY", balance.Posted.String())
+	balances, err := ledger.Balances("fx")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(balances))
+	assert.Equal(t, "-1500 JPY", balances[0].Posted.String())
+	assert.Equal(t, "-10.50 USD", balances[1].Posted.String())
+
+	// Accounts with a currency only take entries in it.
+	err = ledger.Post(NewTransaction(
+		*NewEntry(cash, money(t, "100", "JPY"), common.Debit, common.Posted),
+		*NewEntry(wallet, money(t, "100", "JPY"), common.Credit, common.Posted),
+	))
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+	balance, err = ledger.Balance("wallet-usd")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", balance.Posted.String())
+}
+
+func TestRollupBalance(t *testing.T) {
+	ledger := newTestLedger(t, "equity")
+	_, err := ledger.CreateAccount(&AccountTemplate{
+		Key:      "assets",
+		Currency: "USD",
+		Childrens: []*AccountTemplate{
+			{Key: "bank", Childrens: []*AccountTemplate{{Key: "checking"}, {Key: "savings"}}},
+			{Key: "cash"},
+		},
+	})
+	assert.Nil(t, err)
+	account := func(key string) *Account {
+		account, err := ledger.Account(key)
+		assert.Nil(t, err)
+		return account
+	}
+
+	assert.Nil(t, ledger.Post(NewTransaction(
+		*NewEntry(account("assets/bank/checking"), money(t, "700.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(account("assets/bank/savings"), money(t, "200.00", "USD"), common.Debit, common.Pending),
+		*NewEntry(account("assets/cash"), money(t, "100.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(account("equity"), money(t, "800.00", "USD"), common.Credit, common.Posted),
+		*NewEntry(account("equity"), money(t, "200.00", "USD"), common.Credit, common.Pending),
+	)))
+
+	rollup, err := ledger.RollupBalance("assets")
+	assert.Nil(t, err)
+	assert.Equal(t, "800.00 USD", rollup.Posted.String())
+	assert.Equal(t, "1000.00 USD", rollup.Pending.String())
+
+	rollup, err = ledger.RollupBalance("assets/bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "700.00 USD", rollup.Posted.String())
+
+	own, err := ledger.Balance("assets")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", own.Posted.String())
+
+	// Leaves roll up to themselves.
+	rollup, err = ledger.RollupBalance("assets/cash")
+	assert.Nil(t, err)
+	own, err = ledger.Balance("assets/cash")
+	assert.Nil(t, err)
+	assert.Equal(t, own, rollup)
+
+	// Rollups are rebuilt from the stored transactions.
+	rebuilt, err := NewLedger(ledger.Store())
+	assert.Nil(t, err)
+	balances, err := rebuilt.RollupBalances("assets")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(balances))
+	assert.Equal(t, "800.00 USD", balances[0].Posted.String())
+
+	_, err = ledger.RollupBalance("missing")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+}
+
+func TestNormalBalance(t *testing.T) {
+	chartJSON := `{"accounts": [
+		{"key": "assets", "type": "asset", "children": ["bank"]},
+		{"key": "liabilities", "type": "liability", "children": ["wallets"]},
+		{"key": "income", "type": "income"}
+	]}`
+	chartOfAccounts := &ChartOfAccounts{}
+	assert.Nil(t, json.Unmarshal([]byte(chartJSON), chartOfAccounts))
+	ledger, err := NewLedger(NewMemoryStore())
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.LoadChart(chartOfAccounts))
+
+	wallets, err := ledger.Account("liabilities/wallets")
+	assert.Nil(t, err)
+	assert.Equal(t, common.Liability, wallets.Type)
+	bank, _ := ledger.Account("assets/bank")
+	income, _ := ledger.Account("income")
+
+	assert.Nil(t, ledger.Post(NewTransaction(
+		*NewEntry(bank, money(t, "100.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(wallets, money(t, "100.00", "USD"), common.Credit, common.Posted),
+	)))
+	assert.Nil(t, ledger.Post(NewTransaction(
+		*NewEntry(wallets, money(t, "30.00", "USD"), common.Debit, common.Pending),
+		*NewEntry(income, money(t, "30.00", "USD"), common.Credit, common.Pending),
+	)))
+
+	balance, err := ledger.Balance("assets/bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "100.00 USD", balance.Posted.String())
+
+	// Credits increase a liability, and pending debits hold its funds.
+	balance, err = ledger.RollupBalance("liabilities")
+	assert.Nil(t, err)
+	assert.Equal(t, "100.00 USD", balance.Posted.String())
+	assert.Equal(t, "70.00 USD", balance.Pending.String())
+	assert.Equal(t, "70.00 USD", balance.Available.String())
+
+	balance, err = ledger.Balance("income")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", balance.Posted.String())
+	assert.Equal(t, "30.00 USD", balance.Pending.String())
+}
+
+func TestAccountTypeMismatch(t *testing.T) {
+	ledger := newTestLedger(t)
+	_, err := ledger.CreateAccount(&AccountTemplate{Key: "assets", Type: common.Asset})
+	assert.Nil(t, err)
+
+	_, err = ledger.CreateAccount(&AccountTemplate{
+		Key:       "fees",
+		Type:      common.Expense,
+		Childrens: []*AccountTemplate{{Key: "card"}, {Key: "refunds", Type: common.Income}},
+	})
+	assert.True(t, errors.Is(err, ErrAccountTypeMismatch))
+	assert.Contains(t, err.Error(), "fees/refunds is income but its parent fees is expense")
+
+	_, err = ledger.CreateAccount(&AccountTemplate{Key: "loans", Parent: "assets", Type: common.Liability})
+	assert.True(t, errors.Is(err, ErrAccountTypeMismatch))
+
+	// Accounts created under a typed parent take its type.
+	cash, err := ledger.CreateAccount(&AccountTemplate{Key: "cash", Parent: "assets", Childrens: []*AccountTemplate{{Key: "usd"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, common.Asset, cash.Type)
+	assert.Equal(t, common.Asset, cash.Children[0].Type)
+}
+
+func TestBalanceAsOf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	for _, accountType := range []*AccountTemplate{{Key: "assets"}, {Key: "bank", Parent: "assets"}, {Key: "income"}} {
+		_, err := ledger.CreateAccount(accountType)
+		assert.Nil(t, err)
+	}
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	ledger.now = func() time.Time { return day(10) }
+	deposit := func(amount string, effective time.Time) *Transaction {
+		bank, _ := ledger.Account("bank")
+		income, _ := ledger.Account("income")
+		transaction := NewTransaction(
+			*NewEntry(bank, money(t, amount, "USD"), common.Debit, common.Posted),
+			*NewEntry(income, money(t, amount, "USD"), common.Credit, common.Posted),
+		)
+		transaction.SetEffectiveAt(effective)
+		assert.Nil(t, ledger.Post(transaction))
+		return transaction
+	}
+	asOf := func(ledger *Ledger, key string, at time.Time) string {
+		balance, err := ledger.BalanceAsOf(key, at)
+		assert.Nil(t, err)
+		return balance.Posted.String()
+	}
+
+	today := deposit("100.00", time.Time{})
+	assert.Equal(t, day(10), today.PostedAt())
+	assert.Equal(t, day(10), today.EffectiveAt())
+	backdated := deposit("30.00", day(1))
+	assert.Equal(t, day(10), backdated.PostedAt())
+
+	assert.Equal(t, "0 XXX", asOf(ledger, "bank", day(1).Add(-time.Second)))
+	assert.Equal(t, "30.00 USD", asOf(ledger, "bank", day(1)))
+	assert.Equal(t, "30.00 USD", asOf(ledger, "bank", day(9)))
+	assert.Equal(t, "130.00 USD", asOf(ledger, "bank", day(10)))
+
+	// A posting between two others moves every later balance.
+	deposit("50.00", day(5))
+	assert.Equal(t, "30.00 USD", asOf(ledger, "bank", day(4)))
+	assert.Equal(t, "80.00 USD", asOf(ledger, "bank", day(9)))
+	assert.Equal(t, "180.00 USD", asOf(ledger, "bank", day(31)))
+	balance, err := ledger.RollupBalanceAsOf("assets", day(9))
+	assert.Nil(t, err)
+	assert.Equal(t, "80.00 USD", balance.Posted.String())
+	balances, err := ledger.BalancesAsOf("income", day(5))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(balances))
+	assert.Equal(t, "-80.00 USD", balances[0].Posted.String())
+
+	ledger = reopen(t, ledger, path)
+	assert.Equal(t, "80.00 USD", asOf(ledger, "bank", day(9)))
+	stored, err := ledger.Store().Transaction(backdated.ID())
+	assert.Nil(t, err)
+	assert.Equal(t, day(1), stored.EffectiveAt())
+
+	_, err = TransactionInput{EffectiveDate: "03/05/2024"}.effectiveAt()
+	assert.NotNil(t, err)
+	at, err := TransactionInput{EffectiveDate: "2024-03-05"}.effectiveAt()
+	assert.Nil(t, err)
+	assert.Equal(t, day(5), at)
+}
+
+func TestBalanceHistoryBackdated(t *testing.T) {
+	usd, err := common.LookupCurrency("USD")
+	assert.Nil(t, err)
+	history := &balanceHistory{currency: usd}
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	// Postings arrive out of order and the balances are asked for in between,
+	// each time agreeing with a sum over every posting so far.
+	var posted []int
+	for i, d := range []int{10, 3, 20, 3, 1, 15, 10, 25, 2} {
+		amount := money(t, fmt.Sprintf("%d.00", i+1), "USD")
+		history.apply(day(d), *NewEntry(&Account{Key: "bank"}, amount, common.Debit, common.Posted))
+		posted = append(posted, d)
+		for query := 0; query <= 31; query += 4 {
+			want := 0
+			for j, at := range posted {
+				if at <= query {
+					want += j + 1
+				}
+			}
+			balance, exists := history.asOf(day(query))
+			assert.Equal(t, want > 0, exists)
+			if !exists {
+				continue
+			}
+			assert.Equal(t, fmt.Sprintf("%d.00 USD", want), balance.balance(common.Debit).Posted.String())
+		}
+	}
+}
+
+func TestBalanceHistoryManyPoints(t *testing.T) {
+	usd, err := common.LookupCurrency("USD")
+	assert.Nil(t, err)
+	history := &balanceHistory{currency: usd}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	random := rand.New(rand.NewSource(1))
+
+	perDay := make([]int, 365)
+	for i := 0; i < 2000; i++ {
+		d := random.Intn(len(perDay))
+		history.apply(start.AddDate(0, 0, d), *NewEntry(&Account{Key: "bank"}, money(t, "1.00", "USD"), common.Debit, common.Posted))
+		perDay[d]++
+	}
+	want := 0
+	for d, count := range perDay {
+		want += count
+		balance, exists := history.asOf(start.AddDate(0, 0, d))
+		assert.Equal(t, want > 0, exists)
+		if exists {
+			assert.Equal(t, fmt.Sprintf("%d.00 USD", want), balance.balance(common.Debit).Posted.String())
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ledger/common"
+	"strings"
+)
+
+// planAccounts builds the accounts described by accountTypes and checks them
+// against each other and against the accounts already in store: every key
+// must be new and unique, every parent must exist, parents must not form a
+// cycle and children must have the type of their parent. The accounts are
+// returned with parents before their children.
+func planAccounts(store Store, accountTypes []*AccountTemplate) ([]*Account, error) {
+	accounts := make([]*Account, len(accountTypes))
+	planned := make(map[string]*Account) // every planned account, descendants included
+	var errs []error
+
+	var collect func(account *Account)
+	collect = func(account *Account) {
+		if account.Key == "" || strings.HasSuffix(account.Key, "/") {
+			errs = append(errs, fmt.Errorf("account %q: empty key", account.Key))
+		}
+		if strings.Contains(account.Key, "{{") {
+			errs = append(errs, fmt.Errorf("account %s: only top-level accounts of a chart can be templates", account.Key))
+		}
+		if _, exists := planned[account.Key]; exists {
+			errs = append(errs, fmt.Errorf("%w: %s is declared twice", ErrDuplicateAccount, account.Key))
+		} else if _, err := store.Account(account.Key); err == nil {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrDuplicateAccount, account.Key))
+		} else if !errors.Is(err, ErrAccountNotFound) {
+			errs = append(errs, err)
+		}
+		for _, constraint := range account.Constraints {
+			if err := constraint.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("account %s: %w", account.Key, err))
+			}
+		}
+		planned[account.Key] = account
+		for i := range account.Children {
+			collect(&account.Children[i])
+		}
+	}
+	for i, accountType := range accountTypes {
+		accounts[i] = accountType.CreateAccount()
+		collect(accounts[i])
+	}
+
+	for _, account := range accounts {
+		if account.Parent == "" {
+			continue
+		}
+		if _, exists := planned[account.Parent]; exists {
+			continue
+		}
+		if _, err := store.Account(account.Parent); err != nil {
+			errs = append(errs, fmt.Errorf("account %s: parent: %w", account.Key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// Order the accounts so that each comes after the planned account it is
+	// created under, reporting the accounts whose parents lead back to them.
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	index := make(map[string]int) // planned key to the top-level account holding it
+	for i, account := range accounts {
+		var walk func(account *Account)
+		walk = func(account *Account) {
+			index[account.Key] = i
+			for j := range account.Children {
+				walk(&account.Children[j])
+			}
+		}
+		walk(account)
+	}
+	ordered := make([]*Account, 0, len(accounts))
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		account := accounts[i]
+		switch state[account.Key] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrAccountCycle, strings.Join(append(path, account.Key), " -> "))
+		}
+		state[account.Key] = visiting
+		if parent, exists := index[account.Parent]; exists && account.Parent != "" {
+			if err := visit(parent, append(path, account.Key)); err != nil {
+				return err
+			}
+		}
+		state[account.Key] = done
+		ordered = append(ordered, account)
+		return nil
+	}
+	for i, account := range accounts {
+		if state[account.Key] != unvisited {
+			continue
+		}
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// Accounts created under another one take its type unless they declare
+	// their own, and every child must end up with the type of its parent.
+	for _, account := range ordered {
+		if account.Parent == "" {
+			checkAccountTypes(account, &errs)
+			continue
+		}
+		parent, exists := planned[account.Parent]
+		if !exists {
+			var err error
+			if parent, err = store.Account(account.Parent); err != nil {
+				return nil, err
+			}
+		}
+		if account.Type == common.Unclassified {
+			inheritAccountType(account, parent.Type)
+		}
+		if account.Type != parent.Type {
+			errs = append(errs, fmt.Errorf("%w: %s is %s but its parent %s is %s", ErrAccountTypeMismatch, account.Key, account.Type, parent.Key, parent.Type))
+		}
+		checkAccountTypes(account, &errs)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return ordered, nil
+}
+
+func inheritAccountType(account *Account, accountType common.AccountType) {
+	if account.Type != common.Unclassified {
+		return
+	}
+	account.Type = accountType
+	for i := range account.Children {
+		inheritAccountType(&account.Children[i], accountType)
+	}
+}
+
+func checkAccountTypes(account *Account, errs *[]error) {
+	for i := range account.Children {
+		child := &account.Children[i]
+		if child.Type != account.Type {
+			*errs = append(*errs, fmt.Errorf("%w: %s is %s but its parent %s is %s", ErrAccountTypeMismatch, child.Key, child.Type, account.Key, account.Type))
+		}
+		checkAccountTypes(child, errs)
+	}
+}
+
+// putAccount stores account and its descendants, parents first. The stored
+// accounts leave out Children: the hierarchy is kept by their Parent keys
+// alone, so that it cannot disagree with itself.
+func putAccount(store Store, account *Account) error {
+	stored := *account
+	stored.Children = nil
+	if err := store.PutAccount(&stored); err != nil {
+		return err
+	}
+	for i := range account.Children {
+		if err := putAccount(store, &account.Children[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
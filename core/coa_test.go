@@ -2,9 +2,11 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"ledger/common"
 	"math/big"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -104,18 +106,22 @@ const transactionInput = `
 }
 `
 
-func loadAccounts() {
+func loadAccounts() *Ledger {
 	chartOfAccounts := &ChartOfAccounts{}
 	err := json.Unmarshal([]byte(ChartOfAccountsJson), chartOfAccounts)
 
 	if err != nil {
-		fmt.Errorf("error parsing chart of accounts")
+		panic(fmt.Errorf("error parsing chart of accounts: %w", err))
 	}
 
-	for _, account := range chartOfAccounts.Accounts {
-		account.CreateAccount()
+	ledger, err := NewLedger(NewMemoryStore())
+	if err != nil {
+		panic(err)
 	}
-
+	if err := ledger.LoadChart(chartOfAccounts); err != nil {
+		panic(err)
+	}
+	return ledger
 }
 
 func TestChartOfAccounts(t *testing.T) {
@@ -123,12 +129,20 @@ func TestChartOfAccounts(t *testing.T) {
 	err := json.Unmarshal([]byte(ChartOfAccountsJson), chartOfAccounts)
 	assert.Nil(t, err)
 
+	ledger, err := NewLedger(NewMemoryStore())
+	assert.Nil(t, err)
+
 	for _, account := range chartOfAccounts.Accounts {
-		account.CreateAccount()
-		assert.Equal(t, AccountStore[account.Key].Key, account.Key)
-		assert.Equal(t, AccountStore[account.Key].Name, account.Name)
+		_, err := ledger.CreateAccount(account)
+		assert.Nil(t, err)
+		stored, err := ledger.Account(account.Key)
+		assert.Nil(t, err)
+		assert.Equal(t, stored.Key, account.Key)
+		assert.Equal(t, stored.Name, account.Name)
 	}
-	assert.Equal(t, len(AccountStore), 4)
+	accounts, err := ledger.Store().Accounts()
+	assert.Nil(t, err)
+	assert.Equal(t, len(accounts), 4)
 
 }
 
@@ -147,21 +161,28 @@ func TestCreateAccountWithChildren(t *testing.T) {
 	err := json.Unmarshal([]byte(accountJSON), &chartOfAccounts)
 	assert.Nil(t, err)
 
+	ledger, err := NewLedger(NewMemoryStore())
+	assert.Nil(t, err)
+
 	// Create the account with its children
 	for _, accountTemplate := range chartOfAccounts.Accounts {
-		account := accountTemplate.CreateAccount()
+		account, err := ledger.CreateAccount(accountTemplate)
+		assert.Nil(t, err)
 
 		// Validate that the parent account is correctly added to the store
-		assert.NotNil(t, AccountStore["parent"])
-		assert.Equal(t, "parent", AccountStore["parent"].Key)
-		assert.Equal(t, "Parent Account", AccountStore["parent"].Name)
+		parent, err := ledger.Account("parent")
+		assert.Nil(t, err)
+		assert.Equal(t, "parent", parent.Key)
+		assert.Equal(t, "Parent Account", parent.Name)
 
 		// Validate that the children are correctly added to the store
-		assert.NotNil(t, AccountStore["parent/child1"])
-		assert.Equal(t, "parent/child1", AccountStore["parent/child1"].Key)
+		child1, err := ledger.Account("parent/child1")
+		assert.Nil(t, err)
+		assert.Equal(t, "parent/child1", child1.Key)
 
-		assert.NotNil(t, AccountStore["parent/child2"])
-		assert.Equal(t, "parent/child2", AccountStore["parent/child2"].Key)
+		child2, err := ledger.Account("parent/child2")
+		assert.Nil(t, err)
+		assert.Equal(t, "parent/child2", child2.Key)
 
 		// Validate that the children are correctly linked to the parent
 		assert.Equal(t, 2, len(account.Children))
@@ -179,7 +200,7 @@ type Root struct {
 }
 
 func TestAddTransactionEntry(t *testing.T) {
-	loadAccounts()
+	ledger := loadAccounts()
 
 	root := &Root{}
 	err := json.Unmarshal([]byte(ledgerTransactionsJson), root)
@@ -191,15 +212,16 @@ func TestAddTransactionEntry(t *testing.T) {
 			"tax_payable":      "500",
 		}
 		assert.Equal(t, tt.Type, "sell_something")
-		transaction := CreateTransaction("entry-1", "ledger-1", tt.Type, tt.LedgerEntriesTemplate, params)
+		transaction, err := CreateTransaction(ledger.Store(), "entry-1", "ledger-1", tt.Type, tt.LedgerEntriesTemplate, params)
+		assert.Nil(t, err)
 		assert.Equal(t, len(transaction.entries), 3)
 		assert.Equal(t, transaction.entries[0].Account.Name, "sales_to_bank")
 		assert.Equal(t, transaction.entries[1].Account.Name, "income-root-bank")
 		assert.Equal(t, transaction.entries[2].Account.Name, "tax_payable")
 
-		assert.Equal(t, transaction.entries[0].Amount, big.NewInt(10500))
-		assert.Equal(t, transaction.entries[1].Amount, big.NewInt(10000))
-		assert.Equal(t, transaction.entries[2].Amount, big.NewInt(500))
+		assert.Equal(t, transaction.entries[0].Amount.Units, big.NewInt(10500))
+		assert.Equal(t, transaction.entries[1].Amount.Units, big.NewInt(10000))
+		assert.Equal(t, transaction.entries[2].Amount.Units, big.NewInt(500))
 
 	}
 
@@ -207,7 +229,7 @@ func TestAddTransactionEntry(t *testing.T) {
 }
 
 func TestTransactionFromInput(t *testing.T) {
-	loadAccounts() // Ensure accounts are loaded
+	ledger := loadAccounts() // Ensure accounts are loaded
 
 	// Unmarshal transactionInput into TransactionInput struct
 	var input TransactionInput
@@ -236,21 +258,22 @@ func TestTransactionFromInput(t *testing.T) {
 	}
 
 	// Creating and validating the transaction
-	transaction := tt.CreateTransaction(input)
+	transaction, err := tt.CreateTransaction(ledger.Store(), input)
+	assert.Nil(t, err)
 	assert.Equal(t, len(transaction.entries), 3)
 	assert.Equal(t, transaction.entries[0].Account.Name, "sales_to_bank")
 	assert.Equal(t, transaction.entries[1].Account.Name, "income-root-bank")
 	assert.Equal(t, transaction.entries[2].Account.Name, "tax_payable")
-	assert.Equal(t, transaction.entries[0].Amount, big.NewInt(10500))
-	assert.Equal(t, transaction.entries[1].Amount, big.NewInt(10000))
-	assert.Equal(t, transaction.entries[2].Amount, big.NewInt(500))
+	assert.Equal(t, transaction.entries[0].Amount.Units, big.NewInt(10500))
+	assert.Equal(t, transaction.entries[1].Amount.Units, big.NewInt(10000))
+	assert.Equal(t, transaction.entries[2].Amount.Units, big.NewInt(500))
 	assert.Equal(t, transaction.entries[0].Direction, common.Debit)
 	assert.Equal(t, transaction.entries[1].Direction, common.Credit)
 	assert.Equal(t, transaction.entries[2].Direction, common.Credit)
 }
 
 func TestTransactionWithTemplateAccount(t *testing.T) {
-	loadAccounts() // Ensure accounts are loaded
+	ledger := loadAccounts() // Ensure accounts are loaded
 
 	// Unmarshal transactionInput into TransactionInput struct
 	var input TransactionInput
@@ -278,15 +301,196 @@ func TestTransactionWithTemplateAccount(t *testing.T) {
 	}
 
 	// Creating and validating the transaction
-	transaction := tt.CreateTransaction(input)
+	transaction, err := tt.CreateTransaction(ledger.Store(), input)
+	assert.Nil(t, err)
 	assert.Equal(t, len(transaction.entries), 3)
 	assert.Equal(t, transaction.entries[0].Account.Name, "sales_to_bank")
 	assert.Equal(t, transaction.entries[1].Account.Name, "test_user_account")
 	assert.Equal(t, transaction.entries[2].Account.Name, "tax_payable")
-	assert.Equal(t, transaction.entries[0].Amount, big.NewInt(10500))
-	assert.Equal(t, transaction.entries[1].Amount, big.NewInt(10000))
-	assert.Equal(t, transaction.entries[2].Amount, big.NewInt(500))
+	assert.Equal(t, transaction.entries[0].Amount.Units, big.NewInt(10500))
+	assert.Equal(t, transaction.entries[1].Amount.Units, big.NewInt(10000))
+	assert.Equal(t, transaction.entries[2].Amount.Units, big.NewInt(500))
 	assert.Equal(t, transaction.entries[0].Direction, common.Debit)
 	assert.Equal(t, transaction.entries[1].Direction, common.Credit)
 	assert.Equal(t, transaction.entries[2].Direction, common.Credit)
 }
+
+func TestUnbalancedTransactionRejected(t *testing.T) {
+	ledger := loadAccounts()
+
+	root := &Root{}
+	err := json.Unmarshal([]byte(ledgerTransactionsJson), root)
+	assert.Nil(t, err)
+
+	lines := root.Transactions.Types[0].LedgerEntriesTemplate
+	lines[2].Amount = "{{.tax_payable}} + 1"
+	params := map[string]string{
+		"sales_before_tax": "10000",
+		"tax_payable":      "500",
+	}
+
+	transaction, err := CreateTransaction(ledger.Store(), "entry-2", "ledger-1", "sell_something", lines, params)
+	assert.Nil(t, transaction)
+
+	var unbalanced *UnbalancedError
+	assert.True(t, errors.As(err, &unbalanced))
+	assert.Equal(t, big.NewInt(10500), unbalanced.Debit.Units)
+	assert.Equal(t, big.NewInt(10501), unbalanced.Credit.Units)
+	assert.Equal(t, 3, len(unbalanced.Lines))
+	assert.Equal(t, "tax_payable", unbalanced.Lines[2].Key)
+}
+
+func TestTransactionWithUnknownAccountRejected(t *testing.T) {
+	ledger := loadAccounts()
+
+	root := &Root{}
+	err := json.Unmarshal([]byte(ledgerTransactionsJson), root)
+	assert.Nil(t, err)
+
+	lines := root.Transactions.Types[0].LedgerEntriesTemplate
+	lines[1].AccountKey = "missing"
+	params := map[string]string{
+		"sales_before_tax": "10000",
+		"tax_payable":      "500",
+	}
+
+	transaction, err := CreateTransaction(ledger.Store(), "entry-3", "ledger-1", "sell_something", lines, params)
+	assert.Nil(t, transaction)
+
+	var lineErr *LineError
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, "income-root", lineErr.Key)
+}
+
+func TestNestedChartOfAccounts(t *testing.T) {
+	chartJSON := `{
+	"accounts": [{
+		"key": "assets",
+		"currency": "USD",
+		"children": [
+			{"key": "bank", "children": ["checking", {"key": "savings", "name": "Savings"}]},
+			"cash"
+		]
+	}, {
+		"key": "wallets",
+		"parent": "assets/bank",
+		"children": [{"key": "eur", "currency": "EUR"}]
+	}]
+	}`
+	chartOfAccounts := &ChartOfAccounts{}
+	assert.Nil(t, json.Unmarshal([]byte(chartJSON), chartOfAccounts))
+
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	assert.Nil(t, ledger.LoadChart(chartOfAccounts))
+
+	for _, l := range []*Ledger{ledger, reopen(t, ledger, path)} {
+		savings, err := l.Account("assets/bank/savings")
+		assert.Nil(t, err)
+		assert.Equal(t, "Savings", savings.Name)
+		assert.Equal(t, "USD", savings.Currency)
+		assert.Equal(t, "assets/bank", savings.Parent)
+
+		path, err := l.AccountPath("wallets/eur")
+		assert.Nil(t, err)
+		keys := make([]string, len(path))
+		for i, account := range path {
+			keys[i] = account.Key
+		}
+		assert.Equal(t, []string{"assets", "assets/bank", "wallets", "wallets/eur"}, keys)
+		assert.Equal(t, "EUR", path[3].Currency)
+
+		children, err := l.Children("assets/bank")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(children))
+		assert.Equal(t, "wallets", children[2].Key)
+	}
+}
+
+// reopen closes ledger and opens its journal again.
+func reopen(t *testing.T, ledger *Ledger, path string) *Ledger {
+	assert.Nil(t, ledger.Close())
+	return openJournaledLedger(t, path)
+}
+
+func TestAccountHierarchyStaysShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	_, err := ledger.CreateAccount(&AccountTemplate{Key: "assets", Type: common.Asset, Childrens: []*AccountTemplate{{Key: "bank"}}})
+	assert.Nil(t, err)
+	_, err = ledger.CreateAccount(&AccountTemplate{Key: "equity", Type: common.Equity})
+	assert.Nil(t, err)
+	bank, err := ledger.Account("assets/bank")
+	assert.Nil(t, err)
+	equity, err := ledger.Account("equity")
+	assert.Nil(t, err)
+	transaction := NewTransaction(
+		*NewEntry(bank, money(t, "10.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(equity, money(t, "10.00", "USD"), common.Credit, common.Posted),
+	)
+	assert.Nil(t, ledger.Post(transaction))
+
+	// Accounts added around a stored one, and constraints set on it, are seen
+	// through the entries already posted to it.
+	_, err = ledger.CreateAccount(&AccountTemplate{Key: "cash", Parent: "assets"})
+	assert.Nil(t, err)
+	_, err = ledger.CreateAccount(&AccountTemplate{Key: "savings", Parent: "assets/bank"})
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.SetConstraints("assets/bank", BalanceConstraint{Balance: "posted", Min: "0"}))
+	for _, ledger := range []*Ledger{ledger, reopen(t, ledger, path)} {
+		stored, err := ledger.Store().Transaction(transaction.ID())
+		assert.Nil(t, err)
+		bank, err := ledger.Account("assets/bank")
+		assert.Nil(t, err)
+		assert.Same(t, bank, stored.Entries()[0].Account)
+		assert.Equal(t, 1, len(bank.Constraints))
+		assert.Nil(t, bank.Children)
+
+		children, err := ledger.Children("assets")
+		assert.Nil(t, err)
+		keys := make([]string, len(children))
+		for i, child := range children {
+			keys[i] = child.Key
+		}
+		assert.Equal(t, []string{"assets/bank", "cash"}, keys)
+		children, err = ledger.Children("assets/bank")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(children))
+		assert.Equal(t, "savings", children[0].Key)
+	}
+}
+
+func TestInvalidChartOfAccounts(t *testing.T) {
+	ledger := loadAccounts()
+	load := func(chartJSON string) error {
+		chartOfAccounts := &ChartOfAccounts{}
+		assert.Nil(t, json.Unmarshal([]byte(chartJSON), chartOfAccounts))
+		return ledger.LoadChart(chartOfAccounts)
+	}
+
+	err := load(`{"accounts": [
+		{"key": "a", "children": ["b", "b"]},
+		{"key": "user123"},
+		{"key": "c", "parent": "missing"}
+	]}`)
+	assert.True(t, errors.Is(err, ErrDuplicateAccount))
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+	assert.Contains(t, err.Error(), "a/b is declared twice")
+	assert.Contains(t, err.Error(), "user123")
+
+	err = load(`{"accounts": [
+		{"key": "a", "parent": "b/c"},
+		{"key": "b", "children": ["c"], "parent": "a"}
+	]}`)
+	assert.True(t, errors.Is(err, ErrAccountCycle))
+
+	err = load(`{"accounts": [{"key": "d", "parent": "d"}]}`)
+	assert.True(t, errors.Is(err, ErrAccountCycle))
+
+	// Nothing of a rejected chart is created.
+	_, err = ledger.Account("a")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+
+	_, err = ledger.CreateAccount(&AccountTemplate{Key: "sales_to_bank"})
+	assert.True(t, errors.Is(err, ErrDuplicateAccount))
+}
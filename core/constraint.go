@@ -0,0 +1,155 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ledger/common"
+)
+
+var ErrConstraintViolated = errors.New("balance constraint violated")
+
+// BalanceConstraint bounds a balance of an account, such as "available must
+// stay >= 0" for {"balance": "available", "min": "0"}. Min and Max are
+// decimal amounts; either may be left out. Without Currency the constraint
+// applies to the balance in every currency.
+type BalanceConstraint struct {
+	Balance  string `json:"balance"` // posted, pending or available
+	Min      string `json:"min,omitempty"`
+	Max      string `json:"max,omitempty"`
+	Currency string `json:"currency,omitempty"`
+}
+
+func (c BalanceConstraint) String() string {
+	text := c.Balance
+	if c.Min != "" {
+		text += " >= " + c.Min
+	}
+	if c.Max != "" {
+		text += " <= " + c.Max
+	}
+	if c.Currency != "" {
+		text += " " + c.Currency
+	}
+	return text
+}
+
+func (c BalanceConstraint) validate() error {
+	switch c.Balance {
+	case "posted", "pending", "available":
+	default:
+		return fmt.Errorf("constraint %s: unknown balance %q", c, c.Balance)
+	}
+	if c.Min == "" && c.Max == "" {
+		return fmt.Errorf("constraint %s: needs a min or a max", c)
+	}
+	for _, bound := range []string{c.Min, c.Max} {
+		if bound == "" {
+			continue
+		}
+		if _, err := common.ParseDecimal(bound); err != nil {
+			return fmt.Errorf("constraint %s: %w", c, err)
+		}
+	}
+	if c.Currency != "" {
+		if _, err := common.LookupCurrency(c.Currency); err != nil {
+			return fmt.Errorf("constraint %s: %w", c, err)
+		}
+	}
+	return nil
+}
+
+// value picks the constrained amount out of balance.
+func (c BalanceConstraint) value(balance Balance) common.Money {
+	switch c.Balance {
+	case "pending":
+		return balance.Pending
+	case "available":
+		return balance.Available
+	}
+	return balance.Posted
+}
+
+// violated reports whether moving the constrained balance from before to
+// after breaks c. A balance that is already out of bounds may still move back
+// towards them.
+func (c BalanceConstraint) violated(before Balance, after Balance) bool {
+	if c.Currency != "" && c.Currency != after.Posted.Currency.Code {
+		return false
+	}
+	from, to := c.value(before).Decimal(), c.value(after).Decimal()
+	if c.Min != "" {
+		lower, _ := common.ParseDecimal(c.Min)
+		if to.Cmp(lower) < 0 && to.Cmp(from) < 0 {
+			return true
+		}
+	}
+	if c.Max != "" {
+		upper, _ := common.ParseDecimal(c.Max)
+		if to.Cmp(upper) > 0 && to.Cmp(from) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ConstraintError reports an account whose balance a transaction would have
+// taken out of the bounds of one of its constraints.
+type ConstraintError struct {
+	Account    string
+	Constraint BalanceConstraint
+	Balance    common.Money // the constrained balance the transaction would have left
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("account %s: %v: %s would be %s", e.Account, ErrConstraintViolated, e.Constraint, e.Balance)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return ErrConstraintViolated
+}
+
+// checkConstraints reports a *ConstraintError for every constraint of the
+// accounts of transaction that posting it would violate.
+func (l *Ledger) checkConstraints(transaction *Transaction) error {
+	type accountCurrency struct {
+		account  string
+		currency common.Currency
+	}
+	after := make(map[accountCurrency]*accountBalance)
+	var order []accountCurrency
+	accounts := make(map[string]*Account)
+	for _, entry := range transaction.entries {
+		if len(entry.Account.Constraints) == 0 {
+			continue
+		}
+		key := accountCurrency{entry.Account.Key, entry.Amount.Currency}
+		balance, exists := after[key]
+		if !exists {
+			balance = newAccountBalance(entry.Amount.Currency)
+			if current, exists := l.balances[key.account][key.currency]; exists {
+				balance = current.clone()
+			}
+			after[key] = balance
+			order = append(order, key)
+			accounts[key.account] = entry.Account
+		}
+		balance.apply(entry)
+	}
+
+	var errs []error
+	for _, key := range order {
+		account := accounts[key.account]
+		normal := account.Type.NormalBalance()
+		before := newAccountBalance(key.currency).balance(normal)
+		if current, exists := l.balances[key.account][key.currency]; exists {
+			before = current.balance(normal)
+		}
+		balance := after[key].balance(normal)
+		for _, constraint := range account.Constraints {
+			if constraint.violated(before, balance) {
+				errs = append(errs, &ConstraintError{Account: account.Key, Constraint: constraint, Balance: constraint.value(balance)})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
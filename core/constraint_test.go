@@ -0,0 +1,119 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const constrainedChartJson = `{
+	"accounts": [
+		{"key": "assets", "type": "asset", "children": ["bank"]},
+		{"key": "liabilities", "type": "liability", "children": ["users"]},
+		{
+			"key": "liabilities/users/{{id}}",
+			"parent": "liabilities/users",
+			"auto_create": true,
+			"children": [{
+				"key": "wallet",
+				"constraints": [
+					{"balance": "available", "min": "0"},
+					{"balance": "posted", "max": "100.00", "currency": "USD"}
+				]
+			}]
+		}
+	]
+}`
+
+const transferTemplateJson = `{
+	"type": "transfer",
+	"currency": "USD",
+	"lines": [
+		{"key": "from", "account": "{{.from}}", "amount": "{{.amount}}", "direction": "Debit"},
+		{"key": "to", "account": "{{.to}}", "amount": "{{.amount}}", "direction": "Credit"}
+	]
+}`
+
+func TestBalanceConstraints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	chartOfAccounts := &ChartOfAccounts{}
+	assert.Nil(t, json.Unmarshal([]byte(constrainedChartJson), chartOfAccounts))
+	assert.Nil(t, ledger.LoadChart(chartOfAccounts))
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(transferTemplateJson))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+
+	transfer := func(ledger *Ledger, from string, to string, amount string, pending bool) error {
+		_, err := ledger.CreateTransaction(TransactionInput{
+			Type:       "transfer",
+			Parameters: map[string]string{"from": from, "to": to, "amount": amount},
+			Pending:    pending,
+		})
+		return err
+	}
+	wallet := "liabilities/users/1/wallet"
+
+	assert.Nil(t, transfer(ledger, "assets/bank", wallet, "60.00", false))
+	assert.Nil(t, transfer(ledger, wallet, "assets/bank", "50.00", true))
+
+	// The pending withdrawal holds 50 of the 60.
+	err = transfer(ledger, wallet, "assets/bank", "20.00", false)
+	var constraintErr *ConstraintError
+	assert.True(t, errors.As(err, &constraintErr))
+	assert.True(t, errors.Is(err, ErrConstraintViolated))
+	assert.Equal(t, wallet, constraintErr.Account)
+	assert.Equal(t, "available", constraintErr.Constraint.Balance)
+	assert.Equal(t, "-10.00 USD", constraintErr.Balance.String())
+
+	err = transfer(ledger, "assets/bank", wallet, "50.00", false)
+	assert.True(t, errors.As(err, &constraintErr))
+	assert.Equal(t, "posted <= 100.00 USD", constraintErr.Constraint.String())
+
+	balance, err := ledger.Balance(wallet)
+	assert.Nil(t, err)
+	assert.Equal(t, "60.00 USD", balance.Posted.String())
+	assert.Equal(t, "10.00 USD", balance.Available.String())
+
+	// Constraints set on an account survive a restart.
+	assert.Nil(t, ledger.SetConstraints("assets/bank", BalanceConstraint{Balance: "posted", Max: "65"}))
+	ledger = reopen(t, ledger, path)
+	assert.Nil(t, ledger.AddTemplate(tt))
+	err = transfer(ledger, "assets/bank", wallet, "5.00", false)
+	assert.Nil(t, err)
+	err = transfer(ledger, "assets/bank", wallet, "5.00", false)
+	assert.True(t, errors.As(err, &constraintErr))
+	assert.Equal(t, "assets/bank", constraintErr.Account)
+	assert.Equal(t, "70.00 USD", constraintErr.Balance.String())
+}
+
+func TestConstraintOutOfBounds(t *testing.T) {
+	ledger := newTestLedger(t, "cash", "wallet")
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(transferTemplateJson))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+	input := func(from string, to string, amount string) TransactionInput {
+		return TransactionInput{Type: "transfer", Parameters: map[string]string{"from": from, "to": to, "amount": amount}}
+	}
+
+	_, err = ledger.CreateTransaction(input("cash", "wallet", "30.00"))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.SetConstraints("wallet", BalanceConstraint{Balance: "posted", Min: "0"}))
+
+	// A balance that is already out of bounds can move back towards them,
+	// but not further away.
+	_, err = ledger.CreateTransaction(input("cash", "wallet", "10.00"))
+	assert.True(t, errors.Is(err, ErrConstraintViolated))
+	_, err = ledger.CreateTransaction(input("wallet", "cash", "10.00"))
+	assert.Nil(t, err)
+
+	err = ledger.SetConstraints("wallet", BalanceConstraint{Balance: "spendable", Min: "0"})
+	assert.NotNil(t, err)
+	err = ledger.SetConstraints("wallet", BalanceConstraint{Balance: "posted"})
+	assert.NotNil(t, err)
+	_, err = ledger.CreateAccount(&AccountTemplate{Key: "card", Constraints: []BalanceConstraint{{Balance: "posted", Max: "ten"}}})
+	assert.NotNil(t, err)
+}
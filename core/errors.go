@@ -0,0 +1,64 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ledger/common"
+	"strings"
+)
+
+var (
+	ErrAccountNotFound     = errors.New("account not found")
+	ErrDuplicateAccount    = errors.New("duplicate account")
+	ErrAccountCycle        = errors.New("account hierarchy has a cycle")
+	ErrAccountTypeMismatch = errors.New("account type differs from its parent")
+	ErrIdempotencyConflict = errors.New("idempotency key conflict")
+	ErrCurrencyMismatch    = errors.New("currency mismatch")
+	ErrEmptyTransaction    = errors.New("transaction has no entries")
+	ErrAlreadyReversed     = errors.New("already reversed")
+)
+
+// LineError reports a template line that could not be turned into entries.
+type LineError struct {
+	Key string
+	Err error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %s: %v", e.Key, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// UnbalancedError is returned when the total debit of a transaction differs
+// from its total credit in one currency. Lines holds the entries in that
+// currency.
+type UnbalancedError struct {
+	Debit  common.Money
+	Credit common.Money
+	Lines  []Entries
+}
+
+func (e *UnbalancedError) Error() string {
+	lines := make([]string, len(e.Lines))
+	for i, entry := range e.Lines {
+		lines[i] = fmt.Sprintf("%s %s %s", entry.Key, strings.ToLower(entry.Direction.String()), entry.Amount)
+	}
+	return fmt.Sprintf("unbalanced transaction: debit %s != credit %s [%s]", e.Debit, e.Credit, strings.Join(lines, ", "))
+}
+
+// IdempotencyError is returned when an idempotency key that was already used
+// arrives with a different transaction type or parameters.
+type IdempotencyError struct {
+	Key string
+}
+
+func (e *IdempotencyError) Error() string {
+	return fmt.Sprintf("idempotency key %s was already used for a different transaction", e.Key)
+}
+
+func (e *IdempotencyError) Unwrap() error {
+	return ErrIdempotencyConflict
+}
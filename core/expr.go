@@ -0,0 +1,558 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ledger/common"
+	"strings"
+	"unicode"
+)
+
+// Amount expressions are arithmetic over decimal numbers and template
+// parameters:
+//
+//	{{.sales_before_tax}} + {{.tax_payable}}
+//	round(sales_before_tax * 7.5%, 0, 'half_up')
+//	max(0, {{.balance}} - {{.fee}})
+//
+// Parameters are written either as {{.name}} or as a bare name. Supported are
+// + - * /, parentheses, a postfix % dividing by 100, and the functions
+// min(a, b, ...), max(a, b, ...), abs(x) and round(x, places[, 'mode']), where
+// mode is one of the common.RoundingMode names and defaults to 'half_even'.
+//
+// Conditions additionally use the comparisons == != < <= > >=, the logical
+// operators && || ! and has(name), which tells whether a parameter was given.
+// They evaluate to 1 for true and 0 for false, and any non-zero value is true:
+//
+//	has(tax_payable) && tax_payable > 0
+
+// ExprError reports a malformed expression.
+type ExprError struct {
+	Expr   string
+	Offset int
+	Msg    string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("expression %q: %s at offset %d", e.Expr, e.Msg, e.Offset)
+}
+
+type expr interface {
+	eval(env exprEnv) (common.Decimal, error)
+}
+
+// exprEnv resolves the value of a parameter, failing with an error wrapping
+// errMissingParameter for parameters that were not given.
+type exprEnv func(name string) (common.Decimal, error)
+
+var errMissingParameter = errors.New("missing parameter")
+
+type numberExpr struct {
+	value common.Decimal
+}
+
+type varExpr struct {
+	name string
+}
+
+type negExpr struct {
+	operand expr
+}
+
+type notExpr struct {
+	operand expr
+}
+
+type hasExpr struct {
+	name string
+}
+
+type percentExpr struct {
+	operand expr
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+type callExpr struct {
+	name   string
+	args   []expr
+	places int32               // round only
+	mode   common.RoundingMode // round only
+}
+
+func (e numberExpr) eval(env exprEnv) (common.Decimal, error) {
+	return e.value, nil
+}
+
+func (e varExpr) eval(env exprEnv) (common.Decimal, error) {
+	return env(e.name)
+}
+
+func (e negExpr) eval(env exprEnv) (common.Decimal, error) {
+	value, err := e.operand.eval(env)
+	return value.Neg(), err
+}
+
+func (e notExpr) eval(env exprEnv) (common.Decimal, error) {
+	value, err := e.operand.eval(env)
+	return truth(value.Sign() == 0), err
+}
+
+func (e hasExpr) eval(env exprEnv) (common.Decimal, error) {
+	_, err := env(e.name)
+	return truth(!errors.Is(err, errMissingParameter)), nil
+}
+
+func truth(b bool) common.Decimal {
+	if b {
+		return common.DecimalFromInt(1)
+	}
+	return common.Decimal{}
+}
+
+func (e percentExpr) eval(env exprEnv) (common.Decimal, error) {
+	value, err := e.operand.eval(env)
+	if err != nil {
+		return common.Decimal{}, err
+	}
+	return value.Quo(common.DecimalFromInt(100))
+}
+
+func (e binaryExpr) eval(env exprEnv) (common.Decimal, error) {
+	left, err := e.left.eval(env)
+	if err != nil {
+		return common.Decimal{}, err
+	}
+	// && and || only evaluate their right side when it decides the result.
+	if (e.op == "&&" && left.Sign() == 0) || (e.op == "||" && left.Sign() != 0) {
+		return truth(left.Sign() != 0), nil
+	}
+	right, err := e.right.eval(env)
+	if err != nil {
+		return common.Decimal{}, err
+	}
+	switch e.op {
+	case "+":
+		return left.Add(right), nil
+	case "-":
+		return left.Sub(right), nil
+	case "*":
+		return left.Mul(right), nil
+	case "/":
+		return left.Quo(right)
+	case "&&", "||":
+		return truth(right.Sign() != 0), nil
+	case "==":
+		return truth(left.Cmp(right) == 0), nil
+	case "!=":
+		return truth(left.Cmp(right) != 0), nil
+	case "<":
+		return truth(left.Cmp(right) < 0), nil
+	case "<=":
+		return truth(left.Cmp(right) <= 0), nil
+	case ">":
+		return truth(left.Cmp(right) > 0), nil
+	default:
+		return truth(left.Cmp(right) >= 0), nil
+	}
+}
+
+func (e callExpr) eval(env exprEnv) (common.Decimal, error) {
+	args := make([]common.Decimal, len(e.args))
+	for i, arg := range e.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return common.Decimal{}, err
+		}
+		args[i] = value
+	}
+	switch e.name {
+	case "abs":
+		return args[0].Abs(), nil
+	case "round":
+		return args[0].Round(e.places, e.mode), nil
+	}
+	result := args[0]
+	for _, arg := range args[1:] {
+		if (e.name == "min" && arg.Cmp(result) < 0) || (e.name == "max" && arg.Cmp(result) > 0) {
+			result = arg
+		}
+	}
+	return result, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenVar
+	tokenString
+	tokenOp
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9' || c == '.':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, src[start:i], start})
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && isIdentByte(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, src[start:i], start})
+		case strings.HasPrefix(src[i:], "{{"):
+			end := strings.Index(src[i:], "}}")
+			if end < 0 {
+				return nil, &ExprError{Expr: src, Offset: i, Msg: "unterminated {{"}
+			}
+			name := strings.TrimSpace(src[i+2 : i+end])
+			if !strings.HasPrefix(name, ".") || !isVarName(name[1:]) {
+				return nil, &ExprError{Expr: src, Offset: i, Msg: fmt.Sprintf("invalid parameter reference {{%s}}", name)}
+			}
+			tokens = append(tokens, token{tokenVar, name[1:], i})
+			i += end + 2
+		case c == '\'':
+			end := strings.IndexByte(src[i+1:], '\'')
+			if end < 0 {
+				return nil, &ExprError{Expr: src, Offset: i, Msg: "unterminated string"}
+			}
+			tokens = append(tokens, token{tokenString, src[i+1 : i+1+end], i})
+			i += end + 2
+		case len(src) > i+1 && isTwoCharOp(src[i:i+2]):
+			tokens = append(tokens, token{tokenOp, src[i : i+2], i})
+			i += 2
+		case strings.ContainsRune("+-*/%(),<>!", rune(c)):
+			tokens = append(tokens, token{tokenOp, src[i : i+1], i})
+			i++
+		default:
+			return nil, &ExprError{Expr: src, Offset: i, Msg: fmt.Sprintf("unexpected %q", c)}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(src)}), nil
+}
+
+func isTwoCharOp(op string) bool {
+	switch op {
+	case "==", "!=", "<=", ">=", "&&", "||":
+		return true
+	}
+	return false
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || unicode.IsLetter(rune(c))
+}
+
+// isVarName reports whether name is a dotted list of identifiers.
+func isVarName(name string) bool {
+	for _, part := range strings.Split(name, ".") {
+		if part == "" || part[0] >= '0' && part[0] <= '9' {
+			return false
+		}
+		for i := 0; i < len(part); i++ {
+			if !isIdentByte(part[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type exprParser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+// parseExpr parses src into an expression tree.
+func parseExpr(src string) (expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, p.errorf("empty expression")
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	return e, nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokenOp && t.text == op
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected %q", op)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return &ExprError{Expr: p.src, Offset: p.peek().offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseComparison() (expr, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.isOp(op) {
+			p.next()
+			right, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			return binaryExpr{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") {
+		op := p.next().text
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseTerm() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.isOp("!") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{operand: operand}, nil
+	}
+	if p.isOp("-") || p.isOp("+") {
+		op := p.next().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "-" {
+			return negExpr{operand: operand}, nil
+		}
+		return operand, nil
+	}
+	operand, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("%") {
+		p.next()
+		operand = percentExpr{operand: operand}
+	}
+	return operand, nil
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		value, err := common.ParseDecimal(t.text)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", t.text)
+		}
+		p.next()
+		return numberExpr{value: value}, nil
+	case tokenVar:
+		p.next()
+		return varExpr{name: t.text}, nil
+	case tokenIdent:
+		p.next()
+		if t.text == "has" && p.isOp("(") {
+			return p.parseHas()
+		}
+		if p.isOp("(") {
+			return p.parseCall(t)
+		}
+		return varExpr{name: t.text}, nil
+	case tokenOp:
+		if t.text == "(" {
+			p.next()
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	case tokenEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// parseHas parses the argument list of has, which must be a single parameter.
+func (p *exprParser) parseHas() (expr, error) {
+	p.next() // (
+	t := p.next()
+	if t.kind != tokenVar && t.kind != tokenIdent {
+		return nil, &ExprError{Expr: p.src, Offset: t.offset, Msg: "has needs a parameter name"}
+	}
+	return hasExpr{name: t.text}, p.expect(")")
+}
+
+func (p *exprParser) parseCall(name token) (expr, error) {
+	call := callExpr{name: name.text}
+	p.next() // (
+	var mode *token
+	for !p.isOp(")") {
+		if len(call.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if p.peek().kind == tokenString {
+			t := p.next()
+			mode = &t
+			if !p.isOp(")") {
+				return nil, p.errorf("rounding mode must be the last argument")
+			}
+			continue
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	p.next() // )
+
+	fail := func(msg string) error {
+		return &ExprError{Expr: p.src, Offset: name.offset, Msg: msg}
+	}
+	if mode != nil && (call.name != "round" || len(call.args) != 2) {
+		return nil, &ExprError{Expr: p.src, Offset: mode.offset, Msg: "unexpected string"}
+	}
+	switch call.name {
+	case "min", "max":
+		if len(call.args) == 0 {
+			return nil, fail(call.name + " needs at least one argument")
+		}
+	case "abs":
+		if len(call.args) != 1 {
+			return nil, fail("abs needs one argument")
+		}
+	case "round":
+		if len(call.args) != 2 {
+			return nil, fail("round needs a value and a number of decimal places")
+		}
+		places, ok := call.args[1].(numberExpr)
+		if !ok || !places.value.Exact(0) || places.value.Sign() < 0 || places.value.Cmp(common.DecimalFromInt(common.DivisionScale)) > 0 {
+			return nil, fail(fmt.Sprintf("round places must be a whole number from 0 to %d", common.DivisionScale))
+		}
+		call.places = int32(places.value.Round(0, common.RoundDown).Unscaled().Int64())
+		call.args = call.args[:1]
+		if mode != nil {
+			roundingMode, err := common.ParseRoundingMode(mode.text)
+			if err != nil {
+				return nil, &ExprError{Expr: p.src, Offset: mode.offset, Msg: err.Error()}
+			}
+			call.mode = roundingMode
+		}
+	default:
+		return nil, fail(fmt.Sprintf("unknown function %s", call.name))
+	}
+	return call, nil
+}
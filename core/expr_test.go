@@ -0,0 +1,250 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalExpr(t *testing.T) {
+	params := map[string]string{
+		"sales_before_tax": "10000",
+		"tax_payable":      "500",
+		"rate":             "2.5",
+	}
+	tests := map[string]string{
+		"{{.sales_before_tax}} + {{.tax_payable}}":   "10500",
+		"sales_before_tax - tax_payable * 2":         "9000",
+		"(sales_before_tax - tax_payable) * 2":       "19000",
+		"sales_before_tax * 7.5%":                    "750.000",
+		"sales_before_tax * rate%":                   "250.000",
+		"-tax_payable + 600":                         "100",
+		"min(sales_before_tax, tax_payable, 700)":    "500",
+		"max(0, tax_payable - sales_before_tax)":     "0",
+		"abs(tax_payable - sales_before_tax)":        "9500",
+		"round(10 / 3, 2)":                           "3.33",
+		"round(tax_payable * 0.5%, 0, 'half_up')":    "3",
+		"round(tax_payable * 0.5%, 0, 'half_even')":  "2",
+		"round(-tax_payable * 0.5%, 0, 'floor')":     "-3",
+		"sales_before_tax / 4":                       "2500",
+		"{{ .tax_payable }}/{{.rate}}":               "200",
+		"round(sales_before_tax / 3, 0, 'ceiling')":  "3334",
+		"round(sales_before_tax / 3, 0, 'down') + 1": "3334",
+	}
+	for src, want := range tests {
+		e, err := parseExpr(src)
+		if !assert.Nil(t, err, src) {
+			continue
+		}
+		got, err := e.eval(paramsEnv(params))
+		assert.Nil(t, err, src)
+		assert.Equal(t, want, got.String(), src)
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"{{.a",
+		"{{a}}",
+		"1.2.3",
+		"pow(2, 3)",
+		"abs(1, 2)",
+		"round(1)",
+		"round(1, x)",
+		"round(1, 0.5)",
+		"round(1, 4294967298)",
+		"round(1, 99999999999999999999)",
+		"round(1, -1)",
+		"round(1, 0, 'sideways')",
+		"round(1, 'half_up', 0)",
+		"min('half_up')",
+		"1 $ 2",
+	} {
+		_, err := parseExpr(src)
+		var exprErr *ExprError
+		assert.True(t, errors.As(err, &exprErr), src)
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	params := map[string]string{"bad": "12abc", "zero": "0"}
+	for _, src := range []string{"missing + 1", "bad * 2", "1 / zero"} {
+		e, err := parseExpr(src)
+		assert.Nil(t, err, src)
+		_, err = e.eval(paramsEnv(params))
+		assert.NotNil(t, err, src)
+	}
+}
+
+func TestTemplateAmountErrors(t *testing.T) {
+	templateJson := `{
+		"type": "broken",
+		"lines": [
+			{"key": "ok", "account": "a", "amount": "{{.x}}", "direction": "Debit"},
+			{"key": "bad", "account": "b", "amount": "{{.x}} +", "direction": "Credit"}
+		]
+	}`
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(templateJson))
+	assert.Nil(t, err)
+
+	ledger := newTestLedger(t, "a", "b")
+	_, err = ValidateTemplate(tt, ledger.Store())
+	var lineErr *LineError
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, "bad", lineErr.Key)
+	var exprErr *ExprError
+	assert.True(t, errors.As(err, &exprErr))
+
+	err = ledger.AddTemplate(&TransactionTemplate{
+		Type:                  "broken",
+		LedgerEntriesTemplate: []EntryTemplate{{Key: "bad", Amount: "max()"}},
+	})
+	assert.True(t, errors.As(err, &exprErr))
+
+	tt, err = UnmarshalLedgerTransactionTemplate([]byte(`{"type": "t", "lines": [{"key": "r", "account": "a", "amount": "1", "rounding": "sideways"}]}`))
+	assert.Nil(t, err)
+	_, err = ValidateTemplate(tt, ledger.Store())
+	assert.True(t, errors.As(err, &lineErr))
+}
+
+func TestTemplateReportsEveryExpressionError(t *testing.T) {
+	listJson := `{"types": [{
+		"type": "broken",
+		"lines": [
+			{"key": "one", "account": "a", "amount": "{{.x}} +", "direction": "Debit"},
+			{"key": "two", "account": "walet", "amount": "max()", "direction": "Credit"},
+			{"key": "three", "account": "b", "amount": "* 2", "direction": "Credit"}
+		]
+	}]}`
+	list, err := UnmarshalLedgerTransactionListTemplate([]byte(listJson))
+	assert.Nil(t, err)
+
+	ledger := newTestLedger(t, "a", "b")
+	err = ledger.LoadTemplates(list)
+	var templateErr *TemplateError
+	assert.True(t, errors.As(err, &templateErr))
+	var exprErrors, missing int
+	for _, problem := range templateErr.Problems {
+		var exprErr *ExprError
+		if errors.As(problem, &exprErr) {
+			exprErrors++
+		}
+		if errors.Is(problem, ErrAccountNotFound) {
+			missing++
+		}
+	}
+	assert.Equal(t, 3, exprErrors)
+	assert.Equal(t, 1, missing)
+}
+
+func TestTemplateAmountRounding(t *testing.T) {
+	ledger := newTestLedger(t, "cash", "fees", "revenue")
+	templateJson := `{
+		"type": "fee",
+		"lines": [
+			{"key": "cash", "account": "cash", "amount": "{{.amount}}", "direction": "Debit"},
+			{"key": "fee", "account": "fees", "amount": "{{.amount}} * 2.5%", "direction": "Credit", "rounding": "half_up"},
+			{"key": "revenue", "account": "revenue", "amount": "{{.amount}} - round({{.amount}} * 2.5%, 0, 'half_up')", "direction": "Credit"}
+		]
+	}`
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(templateJson))
+	assert.Nil(t, err)
+
+	transaction, err := tt.CreateTransaction(ledger.Store(), TransactionInput{Parameters: map[string]string{"amount": "1030"}})
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(26), transaction.entries[1].Amount.Units)
+	assert.Equal(t, big.NewInt(1004), transaction.entries[2].Amount.Units)
+
+	tt.LedgerEntriesTemplate[1].Rounding = ""
+	_, err = tt.CreateTransaction(ledger.Store(), TransactionInput{Parameters: map[string]string{"amount": "1010"}})
+	var lineErr *LineError
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, "fee", lineErr.Key)
+
+	_, err = tt.CreateTransaction(ledger.Store(), TransactionInput{Parameters: map[string]string{"amount": "ten"}})
+	assert.True(t, errors.As(err, &lineErr))
+}
+
+func TestEvalCondition(t *testing.T) {
+	params := map[string]string{"tax": "500", "zero": "0", "kind": "2"}
+	tests := map[string]bool{
+		"has(tax)":                       true,
+		"has({{.missing}})":              false,
+		"!has(missing)":                  true,
+		"has(missing) && missing > 0":    false,
+		"!has(missing) || missing > 0":   true,
+		"has(tax) && tax > 0":            true,
+		"tax >= 500 && tax <= 500":       true,
+		"tax != 500 || kind == 2":        true,
+		"zero":                           false,
+		"tax * 10% == 50":                true,
+		"(tax > 100) + (kind > 1) == 2":  true,
+		"max(zero, kind < 1)":            false,
+		"!(tax > 100 && kind < 2)":       true,
+		"tax < 100 || kind == 2 && zero": false,
+	}
+	for src, want := range tests {
+		e, err := parseExpr(src)
+		if !assert.Nil(t, err, src) {
+			continue
+		}
+		got, err := e.eval(paramsEnv(params))
+		assert.Nil(t, err, src)
+		assert.Equal(t, want, got.Sign() != 0, src)
+	}
+
+	for _, src := range []string{"has(1)", "has(tax", "1 < 2 < 3", "tax =< 1", "&& tax"} {
+		_, err := parseExpr(src)
+		assert.NotNil(t, err, src)
+	}
+}
+
+func TestConditionalLines(t *testing.T) {
+	ledger := loadAccounts()
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(`{
+		"type": "sell_something",
+		"parameters": [
+			{"name": "sales_before_tax", "type": "amount", "required": true},
+			{"name": "tax_payable", "type": "amount"},
+			{"name": "discount", "type": "amount", "default": "0"}
+		],
+		"lines": [
+			{"key": "sales_to_bank", "account": "sales_to_bank", "amount": "{{.sales_before_tax}} - {{.discount}}", "direction": "Debit"},
+			{"key": "tax_to_bank", "account": "sales_to_bank", "amount": "{{.tax_payable}}", "direction": "Debit", "condition": "has(tax_payable)", "skip_if_zero": true},
+			{"key": "income-root", "account": "income-root", "amount": "{{.sales_before_tax}}", "direction": "Credit"},
+			{"key": "discount", "account": "user123", "amount": "{{.discount}}", "direction": "Debit", "skip_if_zero": true},
+			{"key": "tax_payable", "account": "tax_payable", "amount": "{{.tax_payable}}", "direction": "Credit", "condition": "has(tax_payable) && tax_payable > 0"}
+		]
+	}`))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+
+	keys := func(transaction *Transaction) []string {
+		var keys []string
+		for _, entry := range transaction.Entries() {
+			keys = append(keys, entry.Key)
+		}
+		return keys
+	}
+	create := func(params map[string]string) (*Transaction, error) {
+		return ledger.CreateTransaction(TransactionInput{Type: "sell_something", Parameters: params})
+	}
+
+	transaction, err := create(map[string]string{"sales_before_tax": "10000", "tax_payable": "500"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sales_to_bank", "tax_to_bank", "income-root", "tax_payable"}, keys(transaction))
+
+	transaction, err = create(map[string]string{"sales_before_tax": "10000"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sales_to_bank", "income-root"}, keys(transaction))
+
+	transaction, err = create(map[string]string{"sales_before_tax": "10000", "tax_payable": "0", "discount": "100"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sales_to_bank", "income-root", "discount"}, keys(transaction))
+}
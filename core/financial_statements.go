@@ -0,0 +1,227 @@
+package core
+
+import (
+	"ledger/common"
+	"sort"
+	"time"
+)
+
+// ReportLine is an account in a financial statement. Amount includes the
+// accounts below it, which are listed in Children as subtotals.
+type ReportLine struct {
+	Account  string       `json:"account"`
+	Name     string       `json:"name,omitempty"`
+	Amount   common.Money `json:"amount"`
+	Children []ReportLine `json:"children,omitempty"`
+}
+
+// ReportSection holds the top-level accounts of one type and their total.
+type ReportSection struct {
+	Type  common.AccountType `json:"type"`
+	Lines []ReportLine       `json:"lines"`
+	Total common.Money       `json:"total"`
+}
+
+// BalanceSheet states the assets, liabilities and equity of the ledger at a
+// time, in one currency. Income and expense accounts are not closed into
+// equity by the ledger, so their net is rolled into equity as earnings:
+// RetainedEarnings up to the start of the period At falls in, and
+// CurrentEarnings since. Without periods all earnings are current.
+type BalanceSheet struct {
+	At                        time.Time     `json:"at"`
+	Currency                  string        `json:"currency"`
+	Assets                    ReportSection `json:"assets"`
+	Liabilities               ReportSection `json:"liabilities"`
+	Equity                    ReportSection `json:"equity"`
+	RetainedEarnings          common.Money  `json:"retained_earnings"`
+	CurrentEarnings           common.Money  `json:"current_earnings"`
+	TotalEquity               common.Money  `json:"total_equity"` // Equity.Total plus earnings
+	TotalLiabilitiesAndEquity common.Money  `json:"total_liabilities_and_equity"`
+}
+
+// Balanced reports whether the assets equal the liabilities plus equity.
+func (bs *BalanceSheet) Balanced() bool {
+	return bs.Assets.Total.Cmp(bs.TotalLiabilitiesAndEquity) == 0
+}
+
+// IncomeStatement states the income and expenses of the ledger over a range of
+// effective times, from From up to but not including To, in one currency.
+type IncomeStatement struct {
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Currency  string        `json:"currency"`
+	Income    ReportSection `json:"income"`
+	Expenses  ReportSection `json:"expenses"`
+	NetIncome common.Money  `json:"net_income"` // Income.Total less Expenses.Total
+}
+
+// BalanceSheet reports the posted balances in the currency code of the asset,
+// liability and equity accounts as of at, following the account hierarchy.
+// Accounts without a type are left out, as are accounts without entries in
+// the currency.
+func (l *Ledger) BalanceSheet(at time.Time, code string) (*BalanceSheet, error) {
+	currency, err := common.LookupCurrency(code)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	chart, err := l.reportChart()
+	if err != nil {
+		return nil, err
+	}
+	periodStart, err := l.periodStart(at)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := func(account *Account) (common.Money, bool) {
+		balance, exists := l.rollupAsOf(account.Key, currency, at)
+		if !exists {
+			return common.Money{}, false
+		}
+		return balance.balance(account.Type.NormalBalance()).Posted, true
+	}
+	sheet := &BalanceSheet{
+		At:          at,
+		Currency:    currency.Code,
+		Assets:      chart.section(common.Asset, currency, amount),
+		Liabilities: chart.section(common.Liability, currency, amount),
+		Equity:      chart.section(common.Equity, currency, amount),
+	}
+	end := at.Add(time.Nanosecond)
+	sheet.RetainedEarnings = common.Zero(currency)
+	if !periodStart.IsZero() {
+		sheet.RetainedEarnings = l.incomeStatement(chart, time.Time{}, periodStart, currency).NetIncome
+	}
+	sheet.CurrentEarnings = l.incomeStatement(chart, periodStart, end, currency).NetIncome
+	sheet.TotalEquity, _ = sheet.Equity.Total.Add(sheet.RetainedEarnings)
+	sheet.TotalEquity, _ = sheet.TotalEquity.Add(sheet.CurrentEarnings)
+	sheet.TotalLiabilitiesAndEquity, _ = sheet.Liabilities.Total.Add(sheet.TotalEquity)
+	return sheet, nil
+}
+
+// IncomeStatement reports the posted entries in the currency code of the
+// income and expense accounts effective from from up to but not including to,
+// following the account hierarchy. A zero from starts at the first entry.
+func (l *Ledger) IncomeStatement(from time.Time, to time.Time, code string) (*IncomeStatement, error) {
+	currency, err := common.LookupCurrency(code)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	chart, err := l.reportChart()
+	if err != nil {
+		return nil, err
+	}
+	return l.incomeStatement(chart, from, to, currency), nil
+}
+
+func (l *Ledger) incomeStatement(chart *reportChart, from time.Time, to time.Time, currency common.Currency) *IncomeStatement {
+	amount := func(account *Account) (common.Money, bool) {
+		closing, exists := l.rollupAsOf(account.Key, currency, to.Add(-time.Nanosecond))
+		if !exists {
+			return common.Money{}, false
+		}
+		opening := newAccountBalance(currency)
+		if !from.IsZero() {
+			if balance, exists := l.rollupAsOf(account.Key, currency, from.Add(-time.Nanosecond)); exists {
+				opening = balance
+			}
+		}
+		return closing.since(opening).balance(account.Type.NormalBalance()).Posted, true
+	}
+	statement := &IncomeStatement{
+		From:     from,
+		To:       to,
+		Currency: currency.Code,
+		Income:   chart.section(common.Income, currency, amount),
+		Expenses: chart.section(common.Expense, currency, amount),
+	}
+	statement.NetIncome, _ = statement.Income.Total.Sub(statement.Expenses.Total)
+	return statement
+}
+
+// rollupAsOf returns the totals of an account and its descendants in currency
+// as of at, and whether there are any.
+func (l *Ledger) rollupAsOf(accountKey string, currency common.Currency, at time.Time) (*accountBalance, bool) {
+	history, exists := l.rollupHistory[accountKey][currency]
+	if !exists {
+		return nil, false
+	}
+	return history.asOf(at)
+}
+
+// periodStart returns the start of the period at falls in, or the zero time
+// when it falls in none.
+func (l *Ledger) periodStart(at time.Time) (time.Time, error) {
+	periods, err := l.store.Periods()
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, period := range periods {
+		if period.contains(at) {
+			return period.Start, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// reportChart is the account hierarchy as financial statements walk it.
+type reportChart struct {
+	roots    []*Account            // accounts without a parent of the same type
+	children map[string][]*Account // by parent key
+}
+
+func (l *Ledger) reportChart() (*reportChart, error) {
+	accounts, err := l.store.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Key < accounts[j].Key })
+	byKey := make(map[string]*Account, len(accounts))
+	for _, account := range accounts {
+		byKey[account.Key] = account
+	}
+	chart := &reportChart{children: make(map[string][]*Account)}
+	for _, account := range accounts {
+		if parent, exists := byKey[account.Parent]; exists && parent.Type == account.Type {
+			chart.children[parent.Key] = append(chart.children[parent.Key], account)
+		} else {
+			chart.roots = append(chart.roots, account)
+		}
+	}
+	return chart, nil
+}
+
+// section lists the accounts of a type for which amount has a value, with
+// their descendants, in order of account key.
+func (chart *reportChart) section(accountType common.AccountType, currency common.Currency, amount func(account *Account) (common.Money, bool)) ReportSection {
+	var line func(account *Account) (ReportLine, bool)
+	line = func(account *Account) (ReportLine, bool) {
+		value, exists := amount(account)
+		if !exists {
+			return ReportLine{}, false
+		}
+		result := ReportLine{Account: account.Key, Name: account.Name, Amount: value}
+		for _, child := range chart.children[account.Key] {
+			if childLine, exists := line(child); exists {
+				result.Children = append(result.Children, childLine)
+			}
+		}
+		return result, true
+	}
+
+	section := ReportSection{Type: accountType, Total: common.Zero(currency)}
+	for _, root := range chart.roots {
+		if root.Type != accountType {
+			continue
+		}
+		if rootLine, exists := line(root); exists {
+			section.Lines = append(section.Lines, rootLine)
+			section.Total, _ = section.Total.Add(rootLine.Amount)
+		}
+	}
+	return section
+}
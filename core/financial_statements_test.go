@@ -0,0 +1,139 @@
+package core
+
+import (
+	"ledger/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinancialStatements(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, accountType := range []*AccountTemplate{
+		{Key: "assets", Name: "Assets", Type: common.Asset},
+		{Key: "assets/bank", Name: "Bank", Parent: "assets"},
+		{Key: "assets/receivables", Name: "Receivables", Parent: "assets"},
+		{Key: "liabilities", Type: common.Liability},
+		{Key: "liabilities/loan", Parent: "liabilities"},
+		{Key: "equity", Type: common.Equity},
+		{Key: "equity/capital", Parent: "equity"},
+		{Key: "income", Type: common.Income},
+		{Key: "income/sales", Parent: "income"},
+		{Key: "income/services", Parent: "income"},
+		{Key: "expenses", Type: common.Expense},
+		{Key: "expenses/rent", Parent: "expenses"},
+	} {
+		_, err := ledger.CreateAccount(accountType)
+		assert.Nil(t, err)
+	}
+	postAt(t, ledger, date(time.January, 5), "assets/bank", "equity/capital", "USD", "1000.00")
+	postAt(t, ledger, date(time.January, 10), "assets/bank", "liabilities/loan", "USD", "500.00")
+	postAt(t, ledger, date(time.February, 3), "assets/receivables", "income/sales", "USD", "300.00")
+	postAt(t, ledger, date(time.February, 10), "assets/bank", "income/services", "USD", "200.00")
+	postAt(t, ledger, date(time.February, 20), "expenses/rent", "assets/bank", "USD", "150.00")
+	postAt(t, ledger, date(time.March, 2), "assets/bank", "income/sales", "USD", "100.00")
+	for month := time.January; month <= time.March; month++ {
+		assert.Nil(t, ledger.AddPeriod(&Period{Name: month.String(), Start: date(month, 1), End: date(month+1, 1)}))
+	}
+
+	statement, err := ledger.IncomeStatement(date(time.February, 1), date(time.March, 1), "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, "500.00 USD", statement.Income.Total.String())
+	assert.Equal(t, []ReportLine{{
+		Account: "income",
+		Amount:  money(t, "500.00", "USD"),
+		Children: []ReportLine{
+			{Account: "income/sales", Amount: money(t, "300.00", "USD")},
+			{Account: "income/services", Amount: money(t, "200.00", "USD")},
+		},
+	}}, statement.Income.Lines)
+	assert.Equal(t, "150.00 USD", statement.Expenses.Total.String())
+	assert.Equal(t, "350.00 USD", statement.NetIncome.String())
+
+	sheet, err := ledger.BalanceSheet(date(time.March, 1).Add(-time.Second), "USD")
+	assert.Nil(t, err)
+	assert.True(t, sheet.Balanced())
+	assert.Equal(t, []ReportLine{{
+		Account: "assets",
+		Name:    "Assets",
+		Amount:  money(t, "1850.00", "USD"),
+		Children: []ReportLine{
+			{Account: "assets/bank", Name: "Bank", Amount: money(t, "1550.00", "USD")},
+			{Account: "assets/receivables", Name: "Receivables", Amount: money(t, "300.00", "USD")},
+		},
+	}}, sheet.Assets.Lines)
+	assert.Equal(t, "500.00 USD", sheet.Liabilities.Total.String())
+	assert.Equal(t, "1000.00 USD", sheet.Equity.Total.String())
+	assert.Equal(t, "0.00 USD", sheet.RetainedEarnings.String())
+	assert.Equal(t, "350.00 USD", sheet.CurrentEarnings.String())
+	assert.Equal(t, "1850.00 USD", sheet.TotalLiabilitiesAndEquity.String())
+
+	// In March, February's earnings are retained.
+	sheet, err = ledger.BalanceSheet(date(time.March, 15), "USD")
+	assert.Nil(t, err)
+	assert.True(t, sheet.Balanced())
+	assert.Equal(t, "1950.00 USD", sheet.Assets.Total.String())
+	assert.Equal(t, "350.00 USD", sheet.RetainedEarnings.String())
+	assert.Equal(t, "100.00 USD", sheet.CurrentEarnings.String())
+	assert.Equal(t, "1450.00 USD", sheet.TotalEquity.String())
+
+	// Nothing was held in euros.
+	sheet, err = ledger.BalanceSheet(date(time.March, 15), "EUR")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(sheet.Assets.Lines))
+	assert.True(t, sheet.Balanced())
+	_, err = ledger.IncomeStatement(time.Time{}, date(time.April, 1), "ABC")
+	assert.NotNil(t, err)
+}
+
+func TestBalanceSheetNetIncomeIntoEquity(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, accountType := range []*AccountTemplate{
+		{Key: "bank", Type: common.Asset},
+		{Key: "capital", Type: common.Equity},
+		{Key: "sales", Type: common.Income},
+		{Key: "wages", Type: common.Expense},
+	} {
+		_, err := ledger.CreateAccount(accountType)
+		assert.Nil(t, err)
+	}
+	postAt(t, ledger, date(time.January, 2), "bank", "capital", "USD", "100.00")
+	postAt(t, ledger, date(time.January, 10), "bank", "sales", "USD", "80.00")
+	postAt(t, ledger, date(time.January, 20), "wages", "bank", "USD", "30.00")
+	postAt(t, ledger, date(time.February, 5), "wages", "bank", "USD", "70.00")
+	postAt(t, ledger, date(time.February, 6), "bank", "sales", "USD", "10.00")
+
+	// Without periods the whole net income is current, and a loss lowers
+	// equity like a profit raises it.
+	sheet, err := ledger.BalanceSheet(date(time.February, 28), "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, "90.00 USD", sheet.Assets.Total.String())
+	assert.Equal(t, "100.00 USD", sheet.Equity.Total.String())
+	assert.Equal(t, "0.00 USD", sheet.RetainedEarnings.String())
+	assert.Equal(t, "-10.00 USD", sheet.CurrentEarnings.String())
+	assert.Equal(t, "90.00 USD", sheet.TotalEquity.String())
+	assert.True(t, sheet.Balanced())
+
+	// With February as a period, January's profit is retained and
+	// February's loss is current. Together they are the net income of the
+	// income statement, and the sheet still balances.
+	assert.Nil(t, ledger.AddPeriod(&Period{Name: "2024-02", Start: date(time.February, 1), End: date(time.March, 1)}))
+	sheet, err = ledger.BalanceSheet(date(time.February, 28), "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, "50.00 USD", sheet.RetainedEarnings.String())
+	assert.Equal(t, "-60.00 USD", sheet.CurrentEarnings.String())
+	assert.Equal(t, "90.00 USD", sheet.TotalEquity.String())
+	assert.True(t, sheet.Balanced())
+	statement, err := ledger.IncomeStatement(time.Time{}, date(time.March, 1), "USD")
+	assert.Nil(t, err)
+	earnings, _ := sheet.RetainedEarnings.Add(sheet.CurrentEarnings)
+	assert.Equal(t, statement.NetIncome, earnings)
+
+	// Before anything was earned, the equity is the capital alone.
+	sheet, err = ledger.BalanceSheet(date(time.January, 5), "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", sheet.CurrentEarnings.String())
+	assert.Equal(t, "100.00 USD", sheet.TotalEquity.String())
+	assert.True(t, sheet.Balanced())
+}
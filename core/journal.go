@@ -0,0 +1,292 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"ledger/common"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	journalHeaderSize    = 8
+	journalMaxRecordSize = 64 << 20
+)
+
+var (
+	ErrJournalCorrupt = errors.New("journal corrupt")
+
+	journalTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Journal is an append-only file of ledger records. Each record is framed as
+// a 4 byte big-endian payload length, a 4 byte CRC-32C of the payload and the
+// JSON payload itself.
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+type journalRecord struct {
+	Account         *Account             `json:"account,omitempty"`
+	AccountTemplate *AccountTemplate     `json:"account_template,omitempty"`
+	Constraints     *constraintsRecord   `json:"constraints,omitempty"`
+	Transaction     *transactionRecord   `json:"transaction,omitempty"`
+	Template        *TransactionTemplate `json:"template,omitempty"`
+	DeletedTemplate *TemplateRef         `json:"deleted_template,omitempty"`
+	ExternalRecord  *ExternalRecord      `json:"external_record,omitempty"`
+	Links           []ReconLink          `json:"links,omitempty"`
+	Unlink          string               `json:"unlink,omitempty"` // id of the external record whose links are removed
+	Period          *Period              `json:"period,omitempty"` // replaces the period of the same name
+}
+
+// constraintsRecord replaces the constraints of an account.
+type constraintsRecord struct {
+	Account     string              `json:"account"`
+	Constraints []BalanceConstraint `json:"constraints"`
+}
+
+type transactionRecord struct {
+	ID          string        `json:"id"`
+	InputHash   string        `json:"input_hash,omitempty"`
+	Template    *TemplateRef  `json:"template,omitempty"`
+	PostedAt    time.Time     `json:"posted_at"`
+	EffectiveAt time.Time     `json:"effective_at"`
+	Reverses    string        `json:"reverses,omitempty"`
+	Adjusts     string        `json:"adjusts,omitempty"`
+	Entries     []entryRecord `json:"entries"`
+}
+
+type entryRecord struct {
+	ID        string           `json:"id"`
+	Key       string           `json:"key,omitempty"`
+	Account   string           `json:"account"`
+	Amount    common.Money     `json:"amount"`
+	Direction common.Direction `json:"direction"`
+	Status    common.Status    `json:"status"`
+	Reverses  string           `json:"reverses,omitempty"`
+	Cancels   bool             `json:"cancels,omitempty"`
+}
+
+// OpenJournal opens or creates the journal at path and returns the records
+// it holds. A torn record at the end of the file, left behind by a crash in
+// the middle of an append, is truncated away. A damaged record followed by
+// intact ones is reported as ErrJournalCorrupt and the file is left as is.
+func OpenJournal(path string) (*Journal, []journalRecord, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, end, err := readJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return &Journal{file: file}, records, nil
+}
+
+// readJournal decodes records from the start of file and returns them with
+// the offset just past the last intact record.
+func readJournal(file *os.File) ([]journalRecord, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+
+	var records []journalRecord
+	var offset int64
+	header := make([]byte, journalHeaderSize)
+	for offset < size {
+		if size-offset < journalHeaderSize {
+			break
+		}
+		if _, err := file.ReadAt(header, offset); err != nil {
+			return nil, 0, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		checksum := binary.BigEndian.Uint32(header[4:8])
+		end := offset + journalHeaderSize + length
+		if length == 0 || length > journalMaxRecordSize || end > size {
+			// Only a frame at the tail can be torn. A bad header followed by
+			// intact records is damage, and truncating would lose them.
+			intact, err := intactFrameAfter(file, offset+1, size)
+			if err != nil {
+				return nil, 0, err
+			}
+			if intact {
+				return nil, 0, fmt.Errorf("%w: bad header at offset %d claims %d bytes", ErrJournalCorrupt, offset, length)
+			}
+			break
+		}
+
+		payload := make([]byte, length)
+		if _, err := file.ReadAt(payload, offset+journalHeaderSize); err != nil {
+			return nil, 0, err
+		}
+		var record journalRecord
+		if crc32.Checksum(payload, journalTable) != checksum || json.Unmarshal(payload, &record) != nil {
+			if end == size {
+				break
+			}
+			return nil, 0, fmt.Errorf("%w: bad record at offset %d", ErrJournalCorrupt, offset)
+		}
+		records = append(records, record)
+		offset = end
+	}
+	return records, offset, nil
+}
+
+// intactFrameAfter reports whether a frame with a valid checksum starts
+// anywhere in file between from and size.
+func intactFrameAfter(file *os.File, from int64, size int64) (bool, error) {
+	if size-from < journalHeaderSize {
+		return false, nil
+	}
+	data := make([]byte, size-from)
+	if _, err := file.ReadAt(data, from); err != nil {
+		return false, err
+	}
+	for i := 0; i+journalHeaderSize <= len(data); i++ {
+		length := int64(binary.BigEndian.Uint32(data[i : i+4]))
+		end := int64(i) + journalHeaderSize + length
+		if length == 0 || length > journalMaxRecordSize || end > int64(len(data)) {
+			continue
+		}
+		if crc32.Checksum(data[i+journalHeaderSize:end], journalTable) == binary.BigEndian.Uint32(data[i+4:i+8]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Append writes record to the end of the journal and syncs it to disk. A
+// frame that could not be written completely is truncated away again.
+func (j *Journal) Append(record journalRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, journalHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, journalTable))
+	copy(frame[journalHeaderSize:], payload)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	offset, err := j.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(frame); err != nil {
+		return j.rollback(offset, err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return j.rollback(offset, err)
+	}
+	return nil
+}
+
+// rollback truncates the journal back to offset after a failed append and
+// returns err.
+func (j *Journal) rollback(offset int64, err error) error {
+	if truncateErr := j.file.Truncate(offset); truncateErr != nil {
+		return errors.Join(err, truncateErr)
+	}
+	if _, seekErr := j.file.Seek(offset, io.SeekStart); seekErr != nil {
+		return errors.Join(err, seekErr)
+	}
+	return err
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+func newTransactionRecord(transaction *Transaction) *transactionRecord {
+	record := &transactionRecord{
+		ID:          transaction.id,
+		PostedAt:    transaction.postedAt,
+		EffectiveAt: transaction.effectiveAt,
+		Reverses:    transaction.reverses,
+		Adjusts:     transaction.adjusts,
+		Entries:     make([]entryRecord, len(transaction.entries)),
+	}
+	if transaction.inputHash != (common.Hash{}) {
+		record.InputHash = hex.EncodeToString(transaction.inputHash[:])
+	}
+	if transaction.template != (TemplateRef{}) {
+		template := transaction.template
+		record.Template = &template
+	}
+	for i, entry := range transaction.entries {
+		record.Entries[i] = entryRecord{
+			ID:        entry.id,
+			Key:       entry.Key,
+			Account:   entry.Account.Key,
+			Amount:    entry.Amount,
+			Direction: entry.Direction,
+			Status:    entry.Status,
+			Reverses:  entry.reverses,
+			Cancels:   entry.cancels,
+		}
+	}
+	return record
+}
+
+// transaction resolves the accounts of record in store.
+func (record *transactionRecord) transaction(store Store) (*Transaction, error) {
+	transaction := &Transaction{
+		id:          record.ID,
+		postedAt:    record.PostedAt,
+		effectiveAt: record.EffectiveAt,
+		reverses:    record.Reverses,
+		adjusts:     record.Adjusts,
+		entries:     make([]Entries, len(record.Entries)),
+	}
+	if transaction.effectiveAt.IsZero() {
+		transaction.effectiveAt = transaction.postedAt
+	}
+	if record.InputHash != "" {
+		inputHash, err := hex.DecodeString(record.InputHash)
+		if err != nil || len(inputHash) != common.HashLength {
+			return nil, fmt.Errorf("%w: transaction %s has a bad input hash", ErrJournalCorrupt, record.ID)
+		}
+		copy(transaction.inputHash[:], inputHash)
+	}
+	if record.Template != nil {
+		transaction.template = *record.Template
+	}
+	for i, entry := range record.Entries {
+		account, err := store.Account(entry.Account)
+		if err != nil {
+			return nil, err
+		}
+		transaction.entries[i] = Entries{
+			id:          entry.ID,
+			transaction: record.ID,
+			Key:         entry.Key,
+			Account:     account,
+			Amount:      entry.Amount,
+			Direction:   entry.Direction,
+			Status:      entry.Status,
+			reverses:    entry.Reverses,
+			cancels:     entry.Cancels,
+		}
+	}
+	return transaction, nil
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openJournaledLedger(t *testing.T, path string) *Ledger {
+	ledger, err := OpenLedger(path, NewMemoryStore())
+	assert.Nil(t, err)
+	t.Cleanup(func() { ledger.Close() })
+	return ledger
+}
+
+// postSales journals the chart of accounts and two sell_something transactions.
+func postSales(t *testing.T, path string) {
+	ledger := openJournaledLedger(t, path)
+	chartOfAccounts := &ChartOfAccounts{}
+	assert.Nil(t, json.Unmarshal([]byte(ChartOfAccountsJson), chartOfAccounts))
+	assert.Nil(t, ledger.LoadChart(chartOfAccounts))
+	loadTemplates(t, ledger, ledgerTransactionsJson)
+
+	var input TransactionInput
+	assert.Nil(t, json.Unmarshal([]byte(transactionInput), &input))
+	for _, ik := range []string{"sale-1", "sale-2"} {
+		input.Ledger.IK = ik
+		_, err := ledger.CreateTransaction(input)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, ledger.Close())
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	postSales(t, path)
+
+	ledger := openJournaledLedger(t, path)
+	account, err := ledger.Account("income-root")
+	assert.Nil(t, err)
+	assert.Equal(t, "income-root-bank", account.Name)
+
+	balance, err := ledger.Balance("sales_to_bank")
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(21000), balance.Posted.Units)
+
+	transaction, err := ledger.Store().Transaction("sale-2")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(transaction.Entries()))
+}
+
+func TestJournalTruncatesTornRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		tear func(t *testing.T, path string, size int64)
+		// posted is the sales_to_bank balance left after recovery.
+		posted int64
+	}{
+		{
+			name: "partial payload",
+			tear: func(t *testing.T, path string, size int64) {
+				assert.Nil(t, os.Truncate(path, size-5))
+			},
+			posted: 10500,
+		},
+		{
+			name: "bad checksum",
+			tear: func(t *testing.T, path string, size int64) {
+				file, err := os.OpenFile(path, os.O_WRONLY, 0)
+				assert.Nil(t, err)
+				_, err = file.WriteAt([]byte{'#'}, size-2)
+				assert.Nil(t, err)
+				assert.Nil(t, file.Close())
+			},
+			posted: 10500,
+		},
+		{
+			name: "partial header",
+			tear: func(t *testing.T, path string, size int64) {
+				file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+				assert.Nil(t, err)
+				_, err = file.Write([]byte{0, 0, 1})
+				assert.Nil(t, err)
+				assert.Nil(t, file.Close())
+			},
+			posted: 21000,
+		},
+		{
+			name: "oversized length",
+			tear: func(t *testing.T, path string, size int64) {
+				file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+				assert.Nil(t, err)
+				_, err = file.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, '{'})
+				assert.Nil(t, err)
+				assert.Nil(t, file.Close())
+			},
+			posted: 21000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ledger.journal")
+			postSales(t, path)
+			info, err := os.Stat(path)
+			assert.Nil(t, err)
+			tt.tear(t, path, info.Size())
+
+			ledger := openJournaledLedger(t, path)
+			balance, err := ledger.Balance("sales_to_bank")
+			assert.Nil(t, err)
+			assert.Equal(t, big.NewInt(tt.posted), balance.Posted.Units)
+
+			// The ledger keeps appending after the truncated tail.
+			loadTemplates(t, ledger, ledgerTransactionsJson)
+			var input TransactionInput
+			assert.Nil(t, json.Unmarshal([]byte(transactionInput), &input))
+			input.Ledger.IK = "sale-3"
+			_, err = ledger.CreateTransaction(input)
+			assert.Nil(t, err)
+			assert.Nil(t, ledger.Close())
+
+			reopened := openJournaledLedger(t, path)
+			balance, err = reopened.Balance("sales_to_bank")
+			assert.Nil(t, err)
+			assert.Equal(t, big.NewInt(tt.posted+10500), balance.Posted.Units)
+		})
+	}
+}
+
+func TestJournalRejectsCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	postSales(t, path)
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{'#'}, journalHeaderSize+2)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	_, err = OpenLedger(path, NewMemoryStore())
+	assert.True(t, errors.Is(err, ErrJournalCorrupt))
+}
+
+func TestJournalRejectsCorruptLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		length uint32
+	}{
+		{name: "past the end of the file", length: 1 << 20},
+		{name: "over the record limit", length: journalMaxRecordSize + 1},
+		{name: "zero", length: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ledger.journal")
+			postSales(t, path)
+			before, err := os.ReadFile(path)
+			assert.Nil(t, err)
+
+			// Damage the length of the second record, which has more after it.
+			second := journalHeaderSize + int64(binary.BigEndian.Uint32(before[0:4]))
+			length := make([]byte, 4)
+			binary.BigEndian.PutUint32(length, tt.length)
+			file, err := os.OpenFile(path, os.O_WRONLY, 0)
+			assert.Nil(t, err)
+			_, err = file.WriteAt(length, second)
+			assert.Nil(t, err)
+			assert.Nil(t, file.Close())
+			damaged, err := os.ReadFile(path)
+			assert.Nil(t, err)
+
+			_, err = OpenLedger(path, NewMemoryStore())
+			assert.True(t, errors.Is(err, ErrJournalCorrupt))
+			after, err := os.ReadFile(path)
+			assert.Nil(t, err)
+			assert.Equal(t, damaged, after)
+			assert.Equal(t, len(before), len(after))
+		})
+	}
+}
@@ -0,0 +1,777 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ledger/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ledger posts transactions to a Store and keeps the balance of every account
+// they touch.
+type Ledger struct {
+	mu       sync.RWMutex
+	store    Store
+	journal  *Journal
+	balances map[string]map[common.Currency]*accountBalance // own balances by account key
+	rollups  map[string]map[common.Currency]*accountBalance // balances including descendants
+	// history and rollupHistory index the same balances by effective time.
+	history       map[string]map[common.Currency]*balanceHistory
+	rollupHistory map[string]map[common.Currency]*balanceHistory
+	now           func() time.Time
+}
+
+// NewLedger returns a ledger backed by store, with balances rebuilt from the
+// transactions already in it.
+func NewLedger(store Store) (*Ledger, error) {
+	ledger := &Ledger{
+		store:    store,
+		balances: make(map[string]map[common.Currency]*accountBalance),
+		rollups:  make(map[string]map[common.Currency]*accountBalance),
+		now:      time.Now,
+
+		history:       make(map[string]map[common.Currency]*balanceHistory),
+		rollupHistory: make(map[string]map[common.Currency]*balanceHistory),
+	}
+	transactions, err := store.Transactions()
+	if err != nil {
+		return nil, err
+	}
+	for _, transaction := range transactions {
+		ledger.applyBalances(transaction)
+	}
+	return ledger, nil
+}
+
+// OpenLedger returns a ledger that journals its accounts and posted
+// transactions to the file at path. The records already in the journal are
+// replayed into store, which should start out empty.
+func OpenLedger(path string, store Store) (*Ledger, error) {
+	journal, records, err := OpenJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if err := replay(store, record); err != nil {
+			journal.Close()
+			return nil, err
+		}
+	}
+	ledger, err := NewLedger(store)
+	if err != nil {
+		journal.Close()
+		return nil, err
+	}
+	ledger.journal = journal
+	return ledger, nil
+}
+
+func replay(store Store, record journalRecord) error {
+	if record.Account != nil {
+		if err := putAccount(store, record.Account); err != nil {
+			return err
+		}
+	}
+	if record.Transaction != nil {
+		transaction, err := record.Transaction.transaction(store)
+		if err != nil {
+			return err
+		}
+		if err := store.PutTransaction(transaction); err != nil {
+			return err
+		}
+	}
+	if record.Constraints != nil {
+		if err := putConstraints(store, record.Constraints.Account, record.Constraints.Constraints); err != nil {
+			return err
+		}
+	}
+	if record.AccountTemplate != nil {
+		if err := store.PutAccountTemplate(record.AccountTemplate); err != nil {
+			return err
+		}
+	}
+	if record.Template != nil {
+		if err := store.PutTemplate(record.Template); err != nil {
+			return err
+		}
+	}
+	if ref := record.DeletedTemplate; ref != nil {
+		if err := store.DeleteTemplate(ref.Type, ref.Version); err != nil {
+			return err
+		}
+	}
+	if record.ExternalRecord != nil {
+		if err := store.PutExternalRecord(record.ExternalRecord); err != nil {
+			return err
+		}
+	}
+	for _, link := range record.Links {
+		if err := store.PutLink(link); err != nil {
+			return err
+		}
+	}
+	if record.Period != nil {
+		if err := store.PutPeriod(record.Period); err != nil {
+			return err
+		}
+	}
+	if record.Unlink != "" {
+		if err := store.DeleteRecordLinks(record.Unlink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the journal of a ledger opened with OpenLedger.
+func (l *Ledger) Close() error {
+	if l.journal == nil {
+		return nil
+	}
+	return l.journal.Close()
+}
+
+func (l *Ledger) Store() Store {
+	return l.store
+}
+
+// CreateAccount stores the account described by accountType and its
+// descendants. It fails with ErrDuplicateAccount when any of their keys is
+// taken. Templated accounts are registered with AddAccountTemplate instead.
+func (l *Ledger) CreateAccount(accountType *AccountTemplate) (*Account, error) {
+	if accountType.Templated() {
+		return nil, fmt.Errorf("account %s: key has placeholders, use AddAccountTemplate", accountType.Key)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	accounts, err := l.createAccounts([]*AccountTemplate{accountType})
+	if err != nil {
+		return nil, err
+	}
+	return accounts[0], nil
+}
+
+// AddAccountTemplate registers a templated account, whose accounts are created
+// by MaterializeAccount or, with AutoCreate, when a transaction uses them.
+func (l *Ledger) AddAccountTemplate(accountType *AccountTemplate) error {
+	if !accountType.Templated() {
+		return fmt.Errorf("account %s: key has no placeholders, use CreateAccount", accountType.Key)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.createAccounts([]*AccountTemplate{accountType})
+	return err
+}
+
+// LoadChart creates every account and registers every templated account of
+// chart, or does nothing when the chart has problems.
+func (l *Ledger) LoadChart(chart *ChartOfAccounts) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.createAccounts(chart.Accounts)
+	return err
+}
+
+func (l *Ledger) createAccounts(accountTypes []*AccountTemplate) ([]*Account, error) {
+	var concrete, templated []*AccountTemplate
+	for _, accountType := range accountTypes {
+		if accountType.Templated() {
+			templated = append(templated, accountType)
+		} else {
+			concrete = append(concrete, accountType)
+		}
+	}
+	accounts, err := planAccounts(l.store, concrete)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := l.store.AccountTemplates()
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, accountType := range existing {
+		keys[accountType.Key] = true
+	}
+	planned := &plannedAccounts{Store: l.store}
+	for _, account := range accounts {
+		planned.add(account)
+	}
+	var errs []error
+	for _, accountType := range templated {
+		if keys[accountType.Key] {
+			errs = append(errs, fmt.Errorf("%w: account template %s", ErrDuplicateAccount, accountType.Key))
+		}
+		keys[accountType.Key] = true
+		errs = append(errs, accountType.validate()...)
+		if parent := accountType.Parent; parent != "" && len(placeholders(parent)) == 0 {
+			if _, err := planned.Account(parent); err != nil {
+				errs = append(errs, fmt.Errorf("account template %s: parent: %w", accountType.Key, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := l.storeAccounts(accounts); err != nil {
+		return nil, err
+	}
+	for _, accountType := range templated {
+		if l.journal != nil {
+			if err := l.journal.Append(journalRecord{AccountTemplate: accountType}); err != nil {
+				return nil, err
+			}
+		}
+		if err := l.store.PutAccountTemplate(accountType); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+// storeAccounts journals and stores planned accounts, parents first.
+func (l *Ledger) storeAccounts(accounts []*Account) error {
+	for _, account := range accounts {
+		if l.journal != nil {
+			if err := l.journal.Append(journalRecord{Account: account}); err != nil {
+				return err
+			}
+		}
+		if err := putAccount(l.store, account); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MaterializeAccount returns the account with the given key, creating it from
+// the account template it matches when it doesn't exist yet. Unlike posting a
+// transaction, it also uses templates without AutoCreate.
+func (l *Ledger) MaterializeAccount(key string) (*Account, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	creator := newAccountCreator(l.store, true)
+	if _, err := creator.Account(key); err != nil {
+		return nil, err
+	}
+	if err := l.storeAccounts(creator.order); err != nil {
+		return nil, err
+	}
+	return l.store.Account(key)
+}
+
+func (l *Ledger) Account(key string) (*Account, error) {
+	return l.store.Account(key)
+}
+
+// SetConstraints replaces the balance constraints of an account. They apply
+// to the transactions posted from then on.
+func (l *Ledger) SetConstraints(accountKey string, constraints ...BalanceConstraint) error {
+	for _, constraint := range constraints {
+		if err := constraint.validate(); err != nil {
+			return fmt.Errorf("account %s: %w", accountKey, err)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.store.Account(accountKey); err != nil {
+		return err
+	}
+	if l.journal != nil {
+		record := &constraintsRecord{Account: accountKey, Constraints: constraints}
+		if err := l.journal.Append(journalRecord{Constraints: record}); err != nil {
+			return err
+		}
+	}
+	return putConstraints(l.store, accountKey, constraints)
+}
+
+// putConstraints updates the stored account in place, so that the entries
+// posted to it keep sharing it.
+func putConstraints(store Store, accountKey string, constraints []BalanceConstraint) error {
+	account, err := store.Account(accountKey)
+	if err != nil {
+		return err
+	}
+	account.Constraints = constraints
+	return store.PutAccount(account)
+}
+
+// Children returns the accounts directly under the account with the given
+// key, in order of key.
+func (l *Ledger) Children(key string) ([]*Account, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, err := l.store.Account(key); err != nil {
+		return nil, err
+	}
+	accounts, err := l.store.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	var children []*Account
+	for _, account := range accounts {
+		if account.Parent == key {
+			children = append(children, account)
+		}
+	}
+	return children, nil
+}
+
+// AccountPath returns the accounts from the root of the hierarchy down to
+// the account with the given key.
+func (l *Ledger) AccountPath(key string) ([]*Account, error) {
+	var path []*Account
+	for key != "" {
+		account, err := l.store.Account(key)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, account)
+		key = account.Parent
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// AddTemplate validates a transaction template against the chart of accounts
+// and registers it as the next version of its type, which CreateTransaction
+// uses from then on. A template identical to the latest version of its type
+// is not registered again and gets that version.
+func (l *Ledger) AddTemplate(template *TransactionTemplate) error {
+	if _, err := ValidateTemplate(template, newAccountCreator(l.store, false)); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.addTemplate(template)
+}
+
+// LoadTemplates validates every template of list and registers them all, or
+// none of them when any template has problems.
+func (l *Ledger) LoadTemplates(list *TransactionsListTemplate) error {
+	var errs []error
+	for i := range list.Types {
+		if _, err := ValidateTemplate(&list.Types[i], newAccountCreator(l.store, false)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range list.Types {
+		if err := l.addTemplate(&list.Types[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Ledger) addTemplate(template *TransactionTemplate) error {
+	latest, err := l.store.Template(template.Type, 0)
+	switch {
+	case err == nil:
+		if sameTemplate(latest, template) {
+			template.Version = latest.Version
+			return nil
+		}
+	case !errors.Is(err, ErrTemplateNotFound):
+		return err
+	}
+	// Numbers of deleted versions are not reused, so that a version pinned
+	// by an input never refers to a different template.
+	last, err := l.store.LastTemplateVersion(template.Type)
+	if err != nil {
+		return err
+	}
+	template.Version = last + 1
+	// Registered versions must not change, so the store gets its own copy.
+	registered := *template
+	registered.Parameters = append([]ParamSpec(nil), template.Parameters...)
+	registered.LedgerEntriesTemplate = append([]EntryTemplate(nil), template.LedgerEntriesTemplate...)
+	for i := range registered.LedgerEntriesTemplate {
+		if err := registered.LedgerEntriesTemplate[i].compile(); err != nil {
+			return err
+		}
+	}
+	if l.journal != nil {
+		if err := l.journal.Append(journalRecord{Template: &registered}); err != nil {
+			return err
+		}
+	}
+	return l.store.PutTemplate(&registered)
+}
+
+// sameTemplate reports whether a and b only differ in their version.
+func sameTemplate(a *TransactionTemplate, b *TransactionTemplate) bool {
+	x, y := *a, *b
+	x.Version, y.Version = 0, 0
+	left, err := json.Marshal(x)
+	if err != nil {
+		return false
+	}
+	right, err := json.Marshal(y)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(left, right)
+}
+
+// DeleteTemplate removes a version of a template. Versions that posted
+// transactions were created from are kept and fail with ErrTemplateInUse.
+func (l *Ledger) DeleteTemplate(transactionType string, version int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.store.Template(transactionType, version); err != nil {
+		return err
+	}
+	ref := TemplateRef{Type: transactionType, Version: version}
+	transactions, err := l.store.Transactions()
+	if err != nil {
+		return err
+	}
+	for _, transaction := range transactions {
+		if transaction.template == ref {
+			return fmt.Errorf("%w: %s version %d is used by transaction %s", ErrTemplateInUse, transactionType, version, transaction.id)
+		}
+	}
+	if l.journal != nil {
+		if err := l.journal.Append(journalRecord{DeletedTemplate: &ref}); err != nil {
+			return err
+		}
+	}
+	return l.store.DeleteTemplate(transactionType, version)
+}
+
+// CreateTransaction builds the transaction described by input from the
+// template registered for input.Type and posts it. input.Ledger.Version pins
+// a version of the template; the latest one is used without it.
+//
+// input.Ledger.IK is an idempotency key: retrying an input whose key was
+// already posted returns the original transaction, while reusing the key for
+// a different type or parameters fails with an *IdempotencyError.
+func (l *Ledger) CreateTransaction(input TransactionInput) (*Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	version, err := input.Ledger.templateVersion()
+	if err != nil {
+		return nil, err
+	}
+	inputHash := input.Hash()
+	if input.Ledger.IK != "" {
+		existing, err := l.store.Transaction(input.Ledger.IK)
+		if err == nil {
+			// A retry may leave the version out or pin the one it got.
+			if existing.inputHash != inputHash || version != 0 && existing.template.Version != version {
+				return nil, &IdempotencyError{Key: input.Ledger.IK}
+			}
+			return existing, nil
+		}
+		if !errors.Is(err, ErrTransactionNotFound) {
+			return nil, err
+		}
+	}
+	template, err := l.store.Template(input.Type, version)
+	if err != nil {
+		return nil, err
+	}
+	creator := newAccountCreator(l.store, false)
+	transaction, err := template.CreateTransaction(creator, input)
+	if err != nil {
+		return nil, err
+	}
+	transaction.inputHash = inputHash
+	if err := l.post(transaction, creator); err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// Post stores a balanced transaction and applies its entries to the account
+// balances. Accounts of auto-created account templates are created as needed.
+// Transactions that would break a constraint of one of their accounts are
+// rejected with a *ConstraintError for each broken constraint.
+func (l *Ledger) Post(transaction *Transaction) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.post(transaction, newAccountCreator(l.store, false))
+}
+
+// post checks and stores transaction, together with the accounts creator
+// planned for it.
+func (l *Ledger) post(transaction *Transaction, creator *accountCreator) error {
+	if err := transaction.Balanced(); err != nil {
+		return err
+	}
+	for i, entry := range transaction.entries {
+		if entry.Account == nil {
+			return fmt.Errorf("entry %s: %w", entry.Key, ErrAccountNotFound)
+		}
+		account, err := creator.Account(entry.Account.Key)
+		if err != nil {
+			return err
+		}
+		if account.Currency != "" && account.Currency != entry.Amount.Currency.Code {
+			return fmt.Errorf("entry %s: %w: account %s holds %s, not %s", entry.Key, ErrCurrencyMismatch, account.Key, account.Currency, entry.Amount.Currency)
+		}
+		transaction.entries[i].Account = account
+	}
+
+	now := l.now().UTC()
+	effectiveAt := transaction.effectiveAt
+	if effectiveAt.IsZero() {
+		effectiveAt = now
+	}
+	if err := l.checkPeriod(effectiveAt); err != nil {
+		return err
+	}
+	if err := l.checkConstraints(transaction); err != nil {
+		return err
+	}
+
+	if _, err := l.store.Transaction(transaction.id); err == nil {
+		return fmt.Errorf("%w: %s", ErrTransactionExists, transaction.id)
+	}
+	keys := make([]string, len(transaction.entries))
+	for i, entry := range transaction.entries {
+		keys[i] = entry.Account.Key
+	}
+	if err := l.storeAccounts(creator.needed(keys)); err != nil {
+		return err
+	}
+	// Entries of planned accounts move over to the stored ones.
+	for i, entry := range transaction.entries {
+		account, err := l.store.Account(entry.Account.Key)
+		if err != nil {
+			return err
+		}
+		transaction.entries[i].Account = account
+	}
+	transaction.postedAt = now
+	transaction.effectiveAt = effectiveAt.UTC()
+	for i := range transaction.entries {
+		transaction.entries[i].transaction = transaction.id
+	}
+	if l.journal != nil {
+		if err := l.journal.Append(journalRecord{Transaction: newTransactionRecord(transaction)}); err != nil {
+			return err
+		}
+	}
+	if err := l.store.PutTransaction(transaction); err != nil {
+		return err
+	}
+	l.applyBalances(transaction)
+	return nil
+}
+
+// applyBalances adds the entries of transaction to the balances of their
+// accounts and to the rollup balances of those accounts and their ancestors,
+// both current and as of its effective time.
+func (l *Ledger) applyBalances(transaction *Transaction) {
+	at := transaction.effectiveAt
+	for _, entry := range transaction.entries {
+		accountBalanceIn(l.balances, entry.Account.Key, entry.Amount.Currency).apply(entry)
+		balanceHistoryIn(l.history, entry.Account.Key, entry.Amount.Currency).apply(at, entry)
+		for account := entry.Account; account != nil; {
+			accountBalanceIn(l.rollups, account.Key, entry.Amount.Currency).apply(entry)
+			balanceHistoryIn(l.rollupHistory, account.Key, entry.Amount.Currency).apply(at, entry)
+			if account.Parent == "" {
+				break
+			}
+			parent, err := l.store.Account(account.Parent)
+			if err != nil {
+				break
+			}
+			account = parent
+		}
+	}
+}
+
+func accountBalanceIn(table map[string]map[common.Currency]*accountBalance, accountKey string, currency common.Currency) *accountBalance {
+	balances, exists := table[accountKey]
+	if !exists {
+		balances = make(map[common.Currency]*accountBalance)
+		table[accountKey] = balances
+	}
+	balance, exists := balances[currency]
+	if !exists {
+		balance = newAccountBalance(currency)
+		balances[currency] = balance
+	}
+	return balance
+}
+
+func balanceHistoryIn(table map[string]map[common.Currency]*balanceHistory, accountKey string, currency common.Currency) *balanceHistory {
+	histories, exists := table[accountKey]
+	if !exists {
+		histories = make(map[common.Currency]*balanceHistory)
+		table[accountKey] = histories
+	}
+	history, exists := histories[currency]
+	if !exists {
+		history = &balanceHistory{currency: currency}
+		histories[currency] = history
+	}
+	return history
+}
+
+// Balance returns the posted, pending and available balance of an account in
+// its currency, signed by the normal side of its type and counting only the
+// entries posted to the account itself. Accounts without a currency report
+// the one currency they hold; use BalanceIn or Balances for accounts holding
+// several.
+func (l *Ledger) Balance(accountKey string) (Balance, error) {
+	return l.balance(l.balances, accountKey)
+}
+
+// BalanceIn returns the own balance of an account in the currency code.
+func (l *Ledger) BalanceIn(accountKey string, code string) (Balance, error) {
+	return l.balanceIn(l.balances, accountKey, code)
+}
+
+// Balances returns the own balance of an account in every currency it holds,
+// sorted by currency code.
+func (l *Ledger) Balances(accountKey string) ([]Balance, error) {
+	return l.balancesOf(l.balances, accountKey)
+}
+
+// RollupBalance is like Balance but includes the entries posted to every
+// descendant of the account.
+func (l *Ledger) RollupBalance(accountKey string) (Balance, error) {
+	return l.balance(l.rollups, accountKey)
+}
+
+// RollupBalanceIn is like BalanceIn but includes the entries posted to every
+// descendant of the account.
+func (l *Ledger) RollupBalanceIn(accountKey string, code string) (Balance, error) {
+	return l.balanceIn(l.rollups, accountKey, code)
+}
+
+// RollupBalances is like Balances but includes the entries posted to every
+// descendant of the account.
+func (l *Ledger) RollupBalances(accountKey string) ([]Balance, error) {
+	return l.balancesOf(l.rollups, accountKey)
+}
+
+// BalanceAsOf is like Balance but counts only the entries of transactions
+// effective at or before at, including those posted later with an earlier
+// effective date.
+func (l *Ledger) BalanceAsOf(accountKey string, at time.Time) (Balance, error) {
+	return l.balance(l.asOf(l.history, accountKey, at), accountKey)
+}
+
+// BalanceInAsOf is like BalanceIn as of at.
+func (l *Ledger) BalanceInAsOf(accountKey string, code string, at time.Time) (Balance, error) {
+	return l.balanceIn(l.asOf(l.history, accountKey, at), accountKey, code)
+}
+
+// BalancesAsOf is like Balances as of at.
+func (l *Ledger) BalancesAsOf(accountKey string, at time.Time) ([]Balance, error) {
+	return l.balancesOf(l.asOf(l.history, accountKey, at), accountKey)
+}
+
+// RollupBalanceAsOf is like RollupBalance as of at.
+func (l *Ledger) RollupBalanceAsOf(accountKey string, at time.Time) (Balance, error) {
+	return l.balance(l.asOf(l.rollupHistory, accountKey, at), accountKey)
+}
+
+// RollupBalanceInAsOf is like RollupBalanceIn as of at.
+func (l *Ledger) RollupBalanceInAsOf(accountKey string, code string, at time.Time) (Balance, error) {
+	return l.balanceIn(l.asOf(l.rollupHistory, accountKey, at), accountKey, code)
+}
+
+// RollupBalancesAsOf is like RollupBalances as of at.
+func (l *Ledger) RollupBalancesAsOf(accountKey string, at time.Time) ([]Balance, error) {
+	return l.balancesOf(l.asOf(l.rollupHistory, accountKey, at), accountKey)
+}
+
+// asOf looks up the totals of an account as of at in histories, as a table
+// for the balance helpers below.
+func (l *Ledger) asOf(histories map[string]map[common.Currency]*balanceHistory, accountKey string, at time.Time) map[string]map[common.Currency]*accountBalance {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	balances := make(map[common.Currency]*accountBalance)
+	for currency, history := range histories[accountKey] {
+		if balance, exists := history.asOf(at); exists {
+			balances[currency] = balance
+		}
+	}
+	return map[string]map[common.Currency]*accountBalance{accountKey: balances}
+}
+
+func (l *Ledger) balance(table map[string]map[common.Currency]*accountBalance, accountKey string) (Balance, error) {
+	account, err := l.store.Account(accountKey)
+	if err != nil {
+		return Balance{}, err
+	}
+	if account.Currency != "" {
+		return l.balanceIn(table, accountKey, account.Currency)
+	}
+
+	normal := account.Type.NormalBalance()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	balances := table[accountKey]
+	switch len(balances) {
+	case 0:
+		currency, _ := common.LookupCurrency(common.NoCurrency)
+		return newAccountBalance(currency).balance(normal), nil
+	case 1:
+		for _, balance := range balances {
+			return balance.balance(normal), nil
+		}
+	}
+	return Balance{}, fmt.Errorf("%w: account %s holds %d currencies", ErrCurrencyMismatch, accountKey, len(balances))
+}
+
+func (l *Ledger) balanceIn(table map[string]map[common.Currency]*accountBalance, accountKey string, code string) (Balance, error) {
+	account, err := l.store.Account(accountKey)
+	if err != nil {
+		return Balance{}, err
+	}
+	currency, err := common.LookupCurrency(code)
+	if err != nil {
+		return Balance{}, err
+	}
+
+	normal := account.Type.NormalBalance()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	balance, exists := table[accountKey][currency]
+	if !exists {
+		return newAccountBalance(currency).balance(normal), nil
+	}
+	return balance.balance(normal), nil
+}
+
+func (l *Ledger) balancesOf(table map[string]map[common.Currency]*accountBalance, accountKey string) ([]Balance, error) {
+	account, err := l.store.Account(accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	normal := account.Type.NormalBalance()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	balances := make([]Balance, 0, len(table[accountKey]))
+	for _, balance := range table[accountKey] {
+		balances = append(balances, balance.balance(normal))
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Posted.Currency.Code < balances[j].Posted.Currency.Code
+	})
+	return balances, nil
+}
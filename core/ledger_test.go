@@ -0,0 +1,256 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTemplates(t *testing.T, ledger *Ledger, templatesJson string) {
+	root := &Root{}
+	assert.Nil(t, json.Unmarshal([]byte(templatesJson), root))
+	for i := range root.Transactions.Types {
+		assert.Nil(t, ledger.AddTemplate(&root.Transactions.Types[i]))
+	}
+}
+
+func TestLedgerCreateTransaction(t *testing.T) {
+	ledger := loadAccounts()
+	loadTemplates(t, ledger, ledgerTransactionsJson)
+
+	var input TransactionInput
+	assert.Nil(t, json.Unmarshal([]byte(transactionInput), &input))
+
+	transaction, err := ledger.CreateTransaction(input)
+	assert.Nil(t, err)
+	assert.Equal(t, "my-ledger-ik", transaction.ID())
+
+	stored, err := ledger.Store().Transaction("my-ledger-ik")
+	assert.Nil(t, err)
+	assert.Equal(t, transaction, stored)
+
+	entries, err := ledger.Store().AccountEntries("tax_payable")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, big.NewInt(500), entries[0].Amount.Units)
+
+	err = ledger.Post(transaction)
+	assert.True(t, errors.Is(err, ErrTransactionExists))
+
+	input.Type = "unknown"
+	input.Ledger.IK = "unknown-ik"
+	_, err = ledger.CreateTransaction(input)
+	assert.True(t, errors.Is(err, ErrTemplateNotFound))
+}
+
+func TestNewLedgerRebuildsBalances(t *testing.T) {
+	ledger := loadAccounts()
+	loadTemplates(t, ledger, ledgerTransactionsJson)
+
+	var input TransactionInput
+	assert.Nil(t, json.Unmarshal([]byte(transactionInput), &input))
+	_, err := ledger.CreateTransaction(input)
+	assert.Nil(t, err)
+
+	reopened, err := NewLedger(ledger.Store())
+	assert.Nil(t, err)
+	balance, err := reopened.Balance("sales_to_bank")
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(10500), balance.Posted.Units)
+}
+
+func TestLedgerIdempotencyKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	chartOfAccounts := &ChartOfAccounts{}
+	assert.Nil(t, json.Unmarshal([]byte(ChartOfAccountsJson), chartOfAccounts))
+	assert.Nil(t, ledger.LoadChart(chartOfAccounts))
+	loadTemplates(t, ledger, ledgerTransactionsJson)
+
+	var input TransactionInput
+	assert.Nil(t, json.Unmarshal([]byte(transactionInput), &input))
+	original, err := ledger.CreateTransaction(input)
+	assert.Nil(t, err)
+
+	retried, err := ledger.CreateTransaction(input)
+	assert.Nil(t, err)
+	assert.Same(t, original, retried)
+
+	balance, err := ledger.Balance("sales_to_bank")
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(10500), balance.Posted.Units)
+
+	changed := input
+	changed.Parameters = map[string]string{"sales_before_tax": "20000", "tax_payable": "500"}
+	_, err = ledger.CreateTransaction(changed)
+	var conflict *IdempotencyError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, "my-ledger-ik", conflict.Key)
+	assert.True(t, errors.Is(err, ErrIdempotencyConflict))
+
+	// A retry pinned to the version the input resolved to is the same
+	// request, one pinned to another version is not.
+	pinned := input
+	pinned.Ledger.Version = "1"
+	retried, err = ledger.CreateTransaction(pinned)
+	assert.Nil(t, err)
+	assert.Same(t, original, retried)
+	pinned.Ledger.Version = "2"
+	_, err = ledger.CreateTransaction(pinned)
+	assert.True(t, errors.As(err, &conflict))
+
+	// Keys survive a restart.
+	assert.Nil(t, ledger.Close())
+	reopened := openJournaledLedger(t, path)
+	loadTemplates(t, reopened, ledgerTransactionsJson)
+	retried, err = reopened.CreateTransaction(input)
+	assert.Nil(t, err)
+	assert.Equal(t, original.ID(), retried.ID())
+	_, err = reopened.CreateTransaction(changed)
+	assert.True(t, errors.Is(err, ErrIdempotencyConflict))
+}
+
+func TestTemplateCurrency(t *testing.T) {
+	ledger := newTestLedger(t, "cash", "revenue", "fees")
+	templateJson := `{
+		"type": "card_sale",
+		"currency": "{{.currency}}",
+		"lines": [
+			{"key": "cash", "account": "cash", "amount": "{{.amount}} - {{.fee}}", "direction": "Debit"},
+			{"key": "fee", "account": "fees", "amount": "{{.fee}}", "direction": "Debit"},
+			{"key": "revenue", "account": "revenue", "amount": "{{.amount}}", "direction": "Credit"}
+		]
+	}`
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(templateJson))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+
+	transaction, err := ledger.CreateTransaction(TransactionInput{
+		Type:       "card_sale",
+		Parameters: map[string]string{"currency": "usd", "amount": "10.50", "fee": "0.31"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.19 USD", transaction.entries[0].Amount.String())
+	assert.Equal(t, "0.31 USD", transaction.entries[1].Amount.String())
+
+	// JPY has no minor units.
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "card_sale",
+		Parameters: map[string]string{"currency": "JPY", "amount": "1050", "fee": "31.5"},
+	})
+	var lineErr *LineError
+	assert.True(t, errors.As(err, &lineErr))
+
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "card_sale",
+		Parameters: map[string]string{"currency": "ABC", "amount": "1", "fee": "0"},
+	})
+	assert.True(t, errors.As(err, &lineErr))
+
+	// A line in another currency cannot balance the rest.
+	tt.LedgerEntriesTemplate[1].Currency = "EUR"
+	_, err = tt.CreateTransaction(ledger.Store(), TransactionInput{
+		Type:       "card_sale",
+		Parameters: map[string]string{"currency": "USD", "amount": "10.50", "fee": "0.31"},
+	})
+	var unbalanced *UnbalancedError
+	assert.True(t, errors.As(err, &unbalanced))
+
+	balance, err := ledger.Balance("revenue")
+	assert.Nil(t, err)
+	assert.Equal(t, "-10.50 USD", balance.Posted.String())
+}
+
+func TestTemplateVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	chartOfAccounts := &ChartOfAccounts{}
+	assert.Nil(t, json.Unmarshal([]byte(ChartOfAccountsJson), chartOfAccounts))
+	assert.Nil(t, ledger.LoadChart(chartOfAccounts))
+
+	root := &Root{}
+	assert.Nil(t, json.Unmarshal([]byte(ledgerTransactionsJson), root))
+	tt := &root.Transactions.Types[0]
+	assert.Nil(t, ledger.AddTemplate(tt))
+	assert.Equal(t, 1, tt.Version)
+	assert.Nil(t, ledger.AddTemplate(tt))
+	assert.Equal(t, 1, tt.Version)
+
+	// Version 2 charges the tax to the customer's account instead.
+	tt.LedgerEntriesTemplate[2].AccountKey = "user123"
+	assert.Nil(t, ledger.AddTemplate(tt))
+	assert.Equal(t, 2, tt.Version)
+
+	var input TransactionInput
+	assert.Nil(t, json.Unmarshal([]byte(transactionInput), &input))
+	latest, err := ledger.CreateTransaction(input)
+	assert.Nil(t, err)
+	assert.Equal(t, TemplateRef{Type: "sell_something", Version: 2}, latest.Template())
+	assert.Equal(t, "user123", latest.entries[2].Account.Key)
+
+	input.Ledger = LedgerInfo{IK: "pinned", Version: "1"}
+	pinned, err := ledger.CreateTransaction(input)
+	assert.Nil(t, err)
+	assert.Equal(t, TemplateRef{Type: "sell_something", Version: 1}, pinned.Template())
+	assert.Equal(t, "tax_payable", pinned.entries[2].Account.Key)
+
+	for _, version := range []string{"3", "latest"} {
+		input.Ledger = LedgerInfo{IK: "missing", Version: version}
+		_, err = ledger.CreateTransaction(input)
+		assert.True(t, errors.Is(err, ErrTemplateNotFound))
+	}
+
+	// Versions survive a restart and stay pinned to what they posted.
+	assert.Nil(t, ledger.Close())
+	ledger = openJournaledLedger(t, path)
+	versions, err := ledger.Store().TemplateVersions("sell_something")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+	stored, err := ledger.Store().Transaction("pinned")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, stored.Template().Version)
+
+	err = ledger.DeleteTemplate("sell_something", 1)
+	assert.True(t, errors.Is(err, ErrTemplateInUse))
+
+	// An unused version can go, and the latest is again the one before it.
+	tt.LedgerEntriesTemplate[2].AccountKey = "income-root"
+	assert.Nil(t, ledger.AddTemplate(tt))
+	assert.Equal(t, 3, tt.Version)
+	assert.Nil(t, ledger.DeleteTemplate("sell_something", 3))
+	current, err := ledger.Store().Template("sell_something", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, current.Version)
+	err = ledger.DeleteTemplate("sell_something", 3)
+	assert.True(t, errors.Is(err, ErrTemplateNotFound))
+
+	assert.Nil(t, ledger.Close())
+	ledger = openJournaledLedger(t, path)
+	versions, err = ledger.Store().TemplateVersions("sell_something")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+
+	// The number of a deleted version is not handed out again, even after a
+	// restart, so an input pinned to it keeps failing.
+	tt.LedgerEntriesTemplate[2].AccountKey = "tax_payable"
+	tt.LedgerEntriesTemplate[1].AccountKey = "user123"
+	assert.Nil(t, ledger.AddTemplate(tt))
+	assert.Equal(t, 4, tt.Version)
+	input.Ledger = LedgerInfo{IK: "deleted", Version: "3"}
+	_, err = ledger.CreateTransaction(input)
+	assert.True(t, errors.Is(err, ErrTemplateNotFound))
+	assert.Nil(t, ledger.DeleteTemplate("sell_something", 4))
+	assert.Nil(t, ledger.Close())
+	ledger = openJournaledLedger(t, path)
+	assert.Nil(t, ledger.AddTemplate(tt))
+	assert.Equal(t, 5, tt.Version)
+	for _, version := range []string{"3", "4"} {
+		input.Ledger = LedgerInfo{IK: "deleted", Version: version}
+		_, err = ledger.CreateTransaction(input)
+		assert.True(t, errors.Is(err, ErrTemplateNotFound))
+	}
+}
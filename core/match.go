@@ -0,0 +1,362 @@
+package core
+
+import (
+	"fmt"
+	"ledger/common"
+	"strings"
+	"time"
+)
+
+// Groupings of a MatchRule.
+const (
+	OneToOne  = "one_to_one"
+	OneToMany = "one_to_many" // one record, several entries of one transaction
+	ManyToOne = "many_to_one" // several records sharing a reference, one entry
+)
+
+// MatchRule describes which external records and entries of an account
+// belong together. Amounts always have to agree within the tolerance of the
+// records; DateWindow and Reference add further conditions.
+type MatchRule struct {
+	Name     string `json:"name"`
+	Grouping string `json:"grouping,omitempty"` // one_to_one when empty
+	// DateWindow is how far, as a time.ParseDuration string such as "72h",
+	// the value date of a record may be from the effective date of the
+	// transaction. Dates are not compared without it.
+	DateWindow string `json:"date_window,omitempty"`
+	// Reference requires the reference of a record to be the id, which is
+	// the idempotency key, of the transaction.
+	Reference bool `json:"reference,omitempty"`
+}
+
+// MatchProposal pairs external records with entries, and explains why.
+type MatchProposal struct {
+	Rule    string   `json:"rule"`
+	Records []string `json:"records"`
+	Entries []string `json:"entries"`
+	Reasons []string `json:"reasons"`
+}
+
+// MatchResult is what Match proposes for an account. Nothing is linked until
+// it is passed to ApplyMatches.
+type MatchResult struct {
+	Account   string          `json:"account"`
+	Proposals []MatchProposal `json:"proposals"`
+	Unmatched []string        `json:"unmatched"` // records no rule found entries for
+}
+
+type openRecord struct {
+	record    *ExternalRecord
+	remaining common.Money
+}
+
+type openEntry struct {
+	entry       Entries
+	remaining   common.Money
+	transaction *Transaction
+}
+
+// matchCandidate is a possible proposal with what it is ranked by: the
+// amount difference first, then the distance between dates, then the order
+// the entries were posted in.
+type matchCandidate struct {
+	records    []*openRecord
+	entries    []*openEntry
+	difference common.Money
+	distance   time.Duration
+	order      int
+	reasons    []string
+}
+
+func (c *matchCandidate) better(o *matchCandidate) bool {
+	if cmp := c.difference.Cmp(o.difference); cmp != 0 {
+		return cmp < 0
+	}
+	if c.distance != o.distance {
+		return c.distance < o.distance
+	}
+	return c.order < o.order
+}
+
+// Match proposes links between the open external records and the open posted
+// entries of an account. The rules are tried in order, each record and entry
+// is proposed at most once, and candidates are ranked so that the same
+// records, entries and rules always give the same result.
+func (l *Ledger) Match(accountKey string, rules ...MatchRule) (*MatchResult, error) {
+	windows := make([]time.Duration, len(rules))
+	for i, rule := range rules {
+		switch rule.Grouping {
+		case "", OneToOne, OneToMany, ManyToOne:
+		default:
+			return nil, fmt.Errorf("rule %s: unknown grouping %q", rule.Name, rule.Grouping)
+		}
+		windows[i] = -1
+		if rule.DateWindow != "" {
+			window, err := time.ParseDuration(rule.DateWindow)
+			if err != nil || window < 0 {
+				return nil, fmt.Errorf("rule %s: invalid date window %q", rule.Name, rule.DateWindow)
+			}
+			windows[i] = window
+		}
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	records, entries, err := l.openItems(accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MatchResult{Account: accountKey}
+	usedRecords := make(map[*openRecord]bool)
+	usedEntries := make(map[*openEntry]bool)
+	propose := func(rule MatchRule, candidate *matchCandidate) {
+		proposal := MatchProposal{Rule: rule.Name, Reasons: candidate.reasons}
+		for _, record := range candidate.records {
+			usedRecords[record] = true
+			proposal.Records = append(proposal.Records, record.record.ID)
+		}
+		for _, entry := range candidate.entries {
+			usedEntries[entry] = true
+			proposal.Entries = append(proposal.Entries, entry.entry.id)
+		}
+		result.Proposals = append(result.Proposals, proposal)
+	}
+
+	for i, rule := range rules {
+		check := func(candidate *matchCandidate) bool {
+			return candidate.check(rule, windows[i])
+		}
+		switch rule.Grouping {
+		case "", OneToOne:
+			for _, record := range records {
+				if usedRecords[record] {
+					continue
+				}
+				var best *matchCandidate
+				for order, entry := range entries {
+					candidate := &matchCandidate{records: []*openRecord{record}, entries: []*openEntry{entry}, order: order}
+					if !usedEntries[entry] && check(candidate) && (best == nil || candidate.better(best)) {
+						best = candidate
+					}
+				}
+				if best != nil {
+					propose(rule, best)
+				}
+			}
+
+		case OneToMany:
+			for _, record := range records {
+				if usedRecords[record] {
+					continue
+				}
+				var best *matchCandidate
+				for order, group := range groupByTransaction(entries, usedEntries) {
+					candidate := &matchCandidate{records: []*openRecord{record}, entries: group, order: order}
+					if len(group) > 1 && check(candidate) && (best == nil || candidate.better(best)) {
+						best = candidate
+					}
+				}
+				if best != nil {
+					propose(rule, best)
+				}
+			}
+
+		case ManyToOne:
+			for _, group := range groupByReference(records, usedRecords) {
+				var best *matchCandidate
+				for order, entry := range entries {
+					candidate := &matchCandidate{records: group, entries: []*openEntry{entry}, order: order}
+					if !usedEntries[entry] && check(candidate) && (best == nil || candidate.better(best)) {
+						best = candidate
+					}
+				}
+				if best != nil {
+					propose(rule, best)
+				}
+			}
+		}
+	}
+
+	for _, record := range records {
+		if !usedRecords[record] {
+			result.Unmatched = append(result.Unmatched, record.record.ID)
+		}
+	}
+	return result, nil
+}
+
+// check reports whether the records and entries of c fit together under rule,
+// and fills in its rank and reasons. window is negative when dates are not
+// compared.
+func (c *matchCandidate) check(rule MatchRule, window time.Duration) bool {
+	first := c.records[0].record
+	recordTotal := common.Zero(first.Amount.Currency)
+	tolerance := common.Zero(first.Amount.Currency)
+	var recordIDs []string
+	for _, record := range c.records {
+		if record.record.Direction != first.Direction || record.remaining.Currency != first.Amount.Currency {
+			return false
+		}
+		recordTotal, _ = recordTotal.Add(record.remaining)
+		tolerance, _ = tolerance.Add(record.record.tolerance())
+		recordIDs = append(recordIDs, record.record.ID)
+	}
+	entryTotal := common.Zero(first.Amount.Currency)
+	var entryAmounts []string
+	for _, entry := range c.entries {
+		if entry.entry.Direction != first.Direction || entry.remaining.Currency != first.Amount.Currency {
+			return false
+		}
+		entryTotal, _ = entryTotal.Add(entry.remaining)
+		entryAmounts = append(entryAmounts, entry.remaining.String())
+	}
+
+	c.difference, _ = recordTotal.Sub(entryTotal)
+	if c.difference.Sign() < 0 {
+		c.difference = c.difference.Neg()
+	}
+	if c.difference.Cmp(tolerance) > 0 {
+		return false
+	}
+	transaction := c.entries[0].transaction
+	c.reasons = []string{fmt.Sprintf("%s of %s matches %s of transaction %s within %s",
+		recordTotal, strings.Join(recordIDs, ", "), strings.Join(entryAmounts, " + "), transaction.id, tolerance)}
+
+	if window >= 0 {
+		for _, record := range c.records {
+			distance := record.record.ValueDate.Sub(transaction.effectiveAt)
+			if distance < 0 {
+				distance = -distance
+			}
+			if record.record.ValueDate.IsZero() || distance > window {
+				return false
+			}
+			if distance > c.distance {
+				c.distance = distance
+			}
+		}
+		c.reasons = append(c.reasons, fmt.Sprintf("value dates are within %s of the transaction effective at %s", rule.DateWindow, transaction.effectiveAt.Format(time.RFC3339)))
+	}
+
+	if rule.Reference {
+		for _, record := range c.records {
+			if record.record.Reference != transaction.id {
+				return false
+			}
+		}
+		c.reasons = append(c.reasons, fmt.Sprintf("reference %s is the id of transaction %s", first.Reference, transaction.id))
+	}
+	return true
+}
+
+// openItems returns the records and posted entries of an account that are not
+// fully matched, in the order they were added.
+func (l *Ledger) openItems(accountKey string) ([]*openRecord, []*openEntry, error) {
+	if _, err := l.store.Account(accountKey); err != nil {
+		return nil, nil, err
+	}
+	storedRecords, err := l.store.ExternalRecords(accountKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	var records []*openRecord
+	for _, record := range storedRecords {
+		state, err := l.recordState(record)
+		if err != nil {
+			return nil, nil, err
+		}
+		if state.Status != Matched && state.Remaining.Sign() > 0 {
+			records = append(records, &openRecord{record: record, remaining: state.Remaining})
+		}
+	}
+
+	storedEntries, err := l.store.AccountEntries(accountKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []*openEntry
+	for _, entry := range storedEntries {
+		if entry.Status != common.Posted {
+			continue
+		}
+		state, err := l.entryState(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		if state.Status == Matched {
+			continue
+		}
+		transaction, err := l.store.Transaction(entry.transaction)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, &openEntry{entry: entry, remaining: state.Remaining, transaction: transaction})
+	}
+	return records, entries, nil
+}
+
+// groupByTransaction groups the unused entries by the transaction they were
+// posted with, in posting order.
+func groupByTransaction(entries []*openEntry, used map[*openEntry]bool) [][]*openEntry {
+	var groups [][]*openEntry
+	index := make(map[string]int)
+	for _, entry := range entries {
+		if used[entry] {
+			continue
+		}
+		i, exists := index[entry.entry.transaction]
+		if !exists {
+			i = len(groups)
+			index[entry.entry.transaction] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], entry)
+	}
+	return groups
+}
+
+// groupByReference groups the unused records that share a reference with at
+// least one other record, in the order they were added.
+func groupByReference(records []*openRecord, used map[*openRecord]bool) [][]*openRecord {
+	var groups [][]*openRecord
+	index := make(map[string]int)
+	for _, record := range records {
+		if used[record] || record.record.Reference == "" {
+			continue
+		}
+		i, exists := index[record.record.Reference]
+		if !exists {
+			i = len(groups)
+			index[record.record.Reference] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], record)
+	}
+	kept := groups[:0]
+	for _, group := range groups {
+		if len(group) > 1 {
+			kept = append(kept, group)
+		}
+	}
+	return kept
+}
+
+// ApplyMatches links the records and entries of every proposal of result. It
+// stops at the first proposal that no longer fits, such as one whose records
+// were linked since Match, and returns the links made until then.
+func (l *Ledger) ApplyMatches(result *MatchResult) ([]ReconLink, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var links []ReconLink
+	for _, proposal := range result.Proposals {
+		for _, recordID := range proposal.Records {
+			made, err := l.link(recordID, proposal.Entries)
+			if err != nil {
+				return links, fmt.Errorf("rule %s: %w", proposal.Rule, err)
+			}
+			links = append(links, made...)
+		}
+	}
+	return links, nil
+}
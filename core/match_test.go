@@ -0,0 +1,226 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income")
+	sale1 := postAt(t, ledger, date(time.March, 1), "bank", "income", "USD", "100.00")
+	postAt(t, ledger, date(time.March, 1), "bank", "income", "USD", "100.00")
+	sale3 := postAt(t, ledger, date(time.March, 5), "bank", "income", "USD", "100.00")
+	payout := postAt(t, ledger, date(time.March, 3), "bank", "income", "USD", "75.00")
+
+	// One sale paid into the bank in two parts.
+	split := postAt(t, ledger, date(time.March, 2), "bank", "income", "USD", "60.00", "40.00")
+
+	addMatchRecord(t, ledger, "line-1", "100.00", 1, sale1.ID())
+	addMatchRecord(t, ledger, "line-2", "100.00", 5, "")
+	addMatchRecord(t, ledger, "line-3", "100.00", 2, "")
+	addMatchRecord(t, ledger, "line-4", "50.00", 3, "payout-7")
+	addMatchRecord(t, ledger, "line-5", "25.00", 4, "payout-7")
+	addMatchRecord(t, ledger, "line-6", "999.00", 1, "")
+
+	rules := []MatchRule{
+		{Name: "reference", Reference: true},
+		{Name: "split", Grouping: OneToMany, DateWindow: "24h"},
+		{Name: "payout", Grouping: ManyToOne, DateWindow: "48h"},
+		{Name: "amount", DateWindow: "120h"},
+	}
+	result, err := ledger.Match("bank", rules...)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(result.Proposals))
+	assert.Equal(t, []string{"line-6"}, result.Unmatched)
+
+	byReference := result.Proposals[0]
+	assert.Equal(t, "reference", byReference.Rule)
+	assert.Equal(t, []string{"line-1"}, byReference.Records)
+	assert.Equal(t, []string{sale1.entries[0].ID()}, byReference.Entries)
+	assert.Equal(t, 2, len(byReference.Reasons))
+
+	oneToMany := result.Proposals[1]
+	assert.Equal(t, []string{"line-3"}, oneToMany.Records)
+	assert.Equal(t, []string{split.entries[0].ID(), split.entries[1].ID()}, oneToMany.Entries)
+
+	manyToOne := result.Proposals[2]
+	assert.Equal(t, []string{"line-4", "line-5"}, manyToOne.Records)
+	assert.Equal(t, []string{payout.entries[0].ID()}, manyToOne.Entries)
+
+	// sale-2 and sale-3 both fit line-2; sale-3 was posted on its value date.
+	byAmount := result.Proposals[3]
+	assert.Equal(t, []string{"line-2"}, byAmount.Records)
+	assert.Equal(t, []string{sale3.entries[0].ID()}, byAmount.Entries)
+
+	again, err := ledger.Match("bank", rules...)
+	assert.Nil(t, err)
+	assert.Equal(t, result, again)
+
+	// Nothing is linked until the result is applied.
+	state, err := ledger.RecordState("line-1")
+	assert.Nil(t, err)
+	assert.Equal(t, Unmatched, state.Status)
+
+	links, err := ledger.ApplyMatches(result)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(links))
+	for _, id := range []string{"line-1", "line-2", "line-3", "line-4", "line-5"} {
+		state, err := ledger.RecordState(id)
+		assert.Nil(t, err)
+		assert.Equal(t, Matched, state.Status, id)
+	}
+	entryState, err := ledger.EntryState(payout.entries[0].ID())
+	assert.Nil(t, err)
+	assert.Equal(t, Matched, entryState.Status)
+
+	result, err = ledger.Match("bank", rules...)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(result.Proposals))
+	assert.Equal(t, []string{"line-6"}, result.Unmatched)
+
+	_, err = ledger.Match("bank", MatchRule{Name: "bad", Grouping: "all"})
+	assert.NotNil(t, err)
+	_, err = ledger.Match("bank", MatchRule{Name: "bad", DateWindow: "3 days"})
+	assert.NotNil(t, err)
+}
+
+// addMatchRecord adds a bank record with a value date in March 2024.
+func addMatchRecord(t *testing.T, ledger *Ledger, id string, amount string, d int, reference string) *ExternalRecord {
+	record := bankRecord(t, id, amount)
+	record.ValueDate = date(time.March, d)
+	record.Reference = reference
+	assert.Nil(t, ledger.AddExternalRecord(record))
+	return record
+}
+
+func TestMatchOneToMany(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income")
+	postAt(t, ledger, date(time.March, 1), "bank", "income", "USD", "100.00")
+	first := postAt(t, ledger, date(time.March, 1), "bank", "income", "USD", "60.00", "40.00")
+	second := postAt(t, ledger, date(time.March, 1), "bank", "income", "USD", "70.00", "25.00")
+	addMatchRecord(t, ledger, "line-1", "100.00", 1, "")
+	loose := bankRecord(t, "line-2", "96.00")
+	loose.Tolerance = "1.00"
+	assert.Nil(t, ledger.AddExternalRecord(loose))
+	addMatchRecord(t, ledger, "line-3", "150.00", 1, "")
+
+	// Single entries are left to one_to_one rules, and the parts of a split
+	// have to add up to the record within its tolerance.
+	result, err := ledger.Match("bank", MatchRule{Name: "split", Grouping: OneToMany})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(result.Proposals))
+	assert.Equal(t, []string{"line-1"}, result.Proposals[0].Records)
+	assert.Equal(t, []string{first.entries[0].ID(), first.entries[1].ID()}, result.Proposals[0].Entries)
+	assert.Equal(t, []string{"line-2"}, result.Proposals[1].Records)
+	assert.Equal(t, []string{second.entries[0].ID(), second.entries[1].ID()}, result.Proposals[1].Entries)
+	assert.Equal(t, []string{"line-3"}, result.Unmatched)
+
+	links, err := ledger.ApplyMatches(result)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(links))
+	state, err := ledger.RecordState("line-2")
+	assert.Nil(t, err)
+	assert.Equal(t, Matched, state.Status)
+}
+
+func TestMatchManyToOne(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income")
+	payout := postDeposit(t, ledger, "payout", "50.00")
+	other := postDeposit(t, ledger, "other", "40.00")
+	addMatchRecord(t, ledger, "line-1", "30.00", 1, "batch-1")
+	addMatchRecord(t, ledger, "line-2", "40.00", 1, "batch-2")
+	addMatchRecord(t, ledger, "line-3", "20.00", 1, "batch-1")
+	addMatchRecord(t, ledger, "line-4", "10.00", 1, "")
+	addMatchRecord(t, ledger, "line-5", "30.00", 1, "")
+
+	// Only records sharing a reference are grouped; the rest are left to a
+	// later rule.
+	rules := []MatchRule{{Name: "batch", Grouping: ManyToOne}, {Name: "amount"}}
+	result, err := ledger.Match("bank", rules...)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(result.Proposals))
+	assert.Equal(t, "batch", result.Proposals[0].Rule)
+	assert.Equal(t, []string{"line-1", "line-3"}, result.Proposals[0].Records)
+	assert.Equal(t, []string{payout.ID()}, result.Proposals[0].Entries)
+	assert.Equal(t, "amount", result.Proposals[1].Rule)
+	assert.Equal(t, []string{"line-2"}, result.Proposals[1].Records)
+	assert.Equal(t, []string{other.ID()}, result.Proposals[1].Entries)
+	assert.Equal(t, []string{"line-4", "line-5"}, result.Unmatched)
+
+	_, err = ledger.ApplyMatches(result)
+	assert.Nil(t, err)
+	state, err := ledger.EntryState(payout.ID())
+	assert.Nil(t, err)
+	assert.Equal(t, Matched, state.Status)
+}
+
+func TestMatchDateWindow(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income")
+	sale := postAt(t, ledger, date(time.March, 10), "bank", "income", "USD", "100.00")
+	addMatchRecord(t, ledger, "line-1", "100.00", 12, "")
+	undated := bankRecord(t, "line-2", "100.00")
+	undated.ValueDate = time.Time{}
+	assert.Nil(t, ledger.AddExternalRecord(undated))
+
+	result, err := ledger.Match("bank", MatchRule{Name: "day", DateWindow: "24h"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(result.Proposals))
+	assert.Equal(t, []string{"line-1", "line-2"}, result.Unmatched)
+
+	// Two days apart fits a window of two days, but a record without a value
+	// date never fits one.
+	result, err = ledger.Match("bank", MatchRule{Name: "days", DateWindow: "48h"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Proposals))
+	assert.Equal(t, []string{"line-1"}, result.Proposals[0].Records)
+	assert.Equal(t, []string{sale.entries[0].ID()}, result.Proposals[0].Entries)
+	assert.Contains(t, result.Proposals[0].Reasons[1], "within 48h")
+	assert.Equal(t, []string{"line-2"}, result.Unmatched)
+
+	result, err = ledger.Match("bank", MatchRule{Name: "any"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Proposals))
+	assert.Equal(t, 1, len(result.Proposals[0].Reasons))
+}
+
+func TestMatchRanking(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income")
+	fifth := postAt(t, ledger, date(time.March, 5), "bank", "income", "USD", "100.00")
+	third := postAt(t, ledger, date(time.March, 3), "bank", "income", "USD", "100.00")
+	fourth := postAt(t, ledger, date(time.March, 4), "bank", "income", "USD", "98.00")
+	first := postAt(t, ledger, date(time.March, 1), "bank", "income", "USD", "101.00")
+	for _, record := range []struct {
+		id, amount, tolerance string
+		d                     int
+	}{
+		{"line-1", "100.00", "2.00", 4},
+		{"line-2", "100.00", "2.00", 1},
+		{"line-3", "99.50", "1.50", 1},
+	} {
+		external := bankRecord(t, record.id, record.amount)
+		external.Tolerance = record.tolerance
+		external.ValueDate = date(time.March, record.d)
+		assert.Nil(t, ledger.AddExternalRecord(external))
+	}
+
+	// line-1 fits every sale. The exact amounts win over the one on its value
+	// date, and of those, equally far off, the sale posted first. line-3 is
+	// as far off the two sales left, and takes the one nearer its date.
+	rules := []MatchRule{{Name: "amount", DateWindow: "96h"}}
+	want := []string{fifth.entries[0].ID(), third.entries[0].ID(), first.entries[0].ID()}
+	for i := 0; i < 5; i++ {
+		result, err := ledger.Match("bank", rules...)
+		assert.Nil(t, err)
+		var got []string
+		for _, proposal := range result.Proposals {
+			got = append(got, proposal.Entries...)
+		}
+		assert.Equal(t, want, got)
+		assert.Nil(t, result.Unmatched)
+	}
+	state, err := ledger.EntryState(fourth.entries[0].ID())
+	assert.Nil(t, err)
+	assert.Equal(t, Unmatched, state.Status)
+}
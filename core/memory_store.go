@@ -0,0 +1,330 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a Store that keeps everything in process memory.
+type MemoryStore struct {
+	mu             sync.RWMutex
+	accounts       map[string]*Account
+	accountTypes   []*AccountTemplate
+	transactions   map[string]*Transaction
+	order          []string
+	reversals      map[string][]string // ids of reversals by reversed transaction id
+	entries        map[string]Entries
+	accountEntries map[string][]string
+	templates      map[string][]*TransactionTemplate // by type, in version order
+	lastVersions   map[string]int                    // highest version ever put, by type
+	records        map[string]*ExternalRecord
+	accountRecords map[string][]string
+	links          []ReconLink
+	periods        map[string]*Period
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		accounts:       make(map[string]*Account),
+		transactions:   make(map[string]*Transaction),
+		reversals:      make(map[string][]string),
+		entries:        make(map[string]Entries),
+		accountEntries: make(map[string][]string),
+		templates:      make(map[string][]*TransactionTemplate),
+		lastVersions:   make(map[string]int),
+		records:        make(map[string]*ExternalRecord),
+		accountRecords: make(map[string][]string),
+		periods:        make(map[string]*Period),
+	}
+}
+
+func (s *MemoryStore) Account(key string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	account, exists := s.accounts[key]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrAccountNotFound, key)
+	}
+	return account, nil
+}
+
+// Accounts returns every account sorted by key.
+func (s *MemoryStore) Accounts() ([]*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Key < accounts[j].Key })
+	return accounts, nil
+}
+
+func (s *MemoryStore) PutAccount(account *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[account.Key] = account
+	return nil
+}
+
+func (s *MemoryStore) AccountTemplates() ([]*AccountTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*AccountTemplate(nil), s.accountTypes...), nil
+}
+
+func (s *MemoryStore) PutAccountTemplate(accountType *AccountTemplate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accountTypes = append(s.accountTypes, accountType)
+	return nil
+}
+
+func (s *MemoryStore) Transaction(id string) (*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	transaction, exists := s.transactions[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, id)
+	}
+	return transaction, nil
+}
+
+func (s *MemoryStore) Transactions() ([]*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	transactions := make([]*Transaction, len(s.order))
+	for i, id := range s.order {
+		transactions[i] = s.transactions[id]
+	}
+	return transactions, nil
+}
+
+func (s *MemoryStore) Reversals(transactionID string) ([]*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := s.reversals[transactionID]
+	transactions := make([]*Transaction, len(ids))
+	for i, id := range ids {
+		transactions[i] = s.transactions[id]
+	}
+	return transactions, nil
+}
+
+func (s *MemoryStore) PutTransaction(transaction *Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.transactions[transaction.id]; exists {
+		return fmt.Errorf("%w: %s", ErrTransactionExists, transaction.id)
+	}
+	s.transactions[transaction.id] = transaction
+	s.order = append(s.order, transaction.id)
+	if transaction.reverses != "" {
+		s.reversals[transaction.reverses] = append(s.reversals[transaction.reverses], transaction.id)
+	}
+	for _, entry := range transaction.entries {
+		s.entries[entry.id] = entry
+		key := entry.Account.Key
+		s.accountEntries[key] = append(s.accountEntries[key], entry.id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Entry(id string) (*Entries, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, exists := s.entries[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, id)
+	}
+	return &entry, nil
+}
+
+// AccountEntries returns the entries posted to an account in posting order.
+func (s *MemoryStore) AccountEntries(accountKey string) ([]Entries, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := s.accountEntries[accountKey]
+	entries := make([]Entries, len(ids))
+	for i, id := range ids {
+		entries[i] = s.entries[id]
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) ExternalRecord(id string) (*ExternalRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, exists := s.records[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrRecordNotFound, id)
+	}
+	return record, nil
+}
+
+func (s *MemoryStore) ExternalRecords(accountKey string) ([]*ExternalRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := s.accountRecords[accountKey]
+	records := make([]*ExternalRecord, len(ids))
+	for i, id := range ids {
+		records[i] = s.records[id]
+	}
+	return records, nil
+}
+
+func (s *MemoryStore) PutExternalRecord(record *ExternalRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.records[record.ID]; exists {
+		return fmt.Errorf("%w: %s", ErrRecordExists, record.ID)
+	}
+	s.records[record.ID] = record
+	s.accountRecords[record.Account] = append(s.accountRecords[record.Account], record.ID)
+	return nil
+}
+
+func (s *MemoryStore) RecordLinks(recordID string) ([]ReconLink, error) {
+	return s.findLinks(func(link ReconLink) bool { return link.Record == recordID }), nil
+}
+
+func (s *MemoryStore) EntryLinks(entryID string) ([]ReconLink, error) {
+	return s.findLinks(func(link ReconLink) bool { return link.Entry == entryID }), nil
+}
+
+func (s *MemoryStore) findLinks(matches func(link ReconLink) bool) []ReconLink {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var links []ReconLink
+	for _, link := range s.links {
+		if matches(link) {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+func (s *MemoryStore) PutLink(link ReconLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links = append(s.links, link)
+	return nil
+}
+
+func (s *MemoryStore) DeleteRecordLinks(recordID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.links[:0]
+	for _, link := range s.links {
+		if link.Record != recordID {
+			kept = append(kept, link)
+		}
+	}
+	s.links = kept
+	return nil
+}
+
+func (s *MemoryStore) Template(transactionType string, version int) (*TransactionTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := s.templates[transactionType]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, transactionType)
+	}
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+	for _, template := range versions {
+		if template.Version == version {
+			return template, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s version %d", ErrTemplateNotFound, transactionType, version)
+}
+
+// Templates returns the latest version of every template sorted by type.
+func (s *MemoryStore) Templates() ([]*TransactionTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	templates := make([]*TransactionTemplate, 0, len(s.templates))
+	for _, versions := range s.templates {
+		templates = append(templates, versions[len(versions)-1])
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Type < templates[j].Type })
+	return templates, nil
+}
+
+// TemplateVersions returns every version of a template, oldest first.
+func (s *MemoryStore) TemplateVersions(transactionType string) ([]*TransactionTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := s.templates[transactionType]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, transactionType)
+	}
+	return append([]*TransactionTemplate(nil), versions...), nil
+}
+
+func (s *MemoryStore) LastTemplateVersion(transactionType string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastVersions[transactionType], nil
+}
+
+func (s *MemoryStore) PutTemplate(template *TransactionTemplate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if template.Version <= s.lastVersions[template.Type] {
+		return fmt.Errorf("%w: %s version %d", ErrTemplateExists, template.Type, template.Version)
+	}
+	s.templates[template.Type] = append(s.templates[template.Type], template)
+	s.lastVersions[template.Type] = template.Version
+	return nil
+}
+
+func (s *MemoryStore) DeleteTemplate(transactionType string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.templates[transactionType]
+	for i, template := range versions {
+		if template.Version == version {
+			versions = append(versions[:i:i], versions[i+1:]...)
+			if len(versions) == 0 {
+				delete(s.templates, transactionType)
+			} else {
+				s.templates[transactionType] = versions
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s version %d", ErrTemplateNotFound, transactionType, version)
+}
+
+func (s *MemoryStore) Period(name string) (*Period, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	period, exists := s.periods[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPeriodNotFound, name)
+	}
+	return period, nil
+}
+
+func (s *MemoryStore) Periods() ([]*Period, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	periods := make([]*Period, 0, len(s.periods))
+	for _, period := range s.periods {
+		periods = append(periods, period)
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Start.Before(periods[j].Start) })
+	return periods, nil
+}
+
+func (s *MemoryStore) PutPeriod(period *Period) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.periods[period.Name] = period
+	return nil
+}
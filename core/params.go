@@ -0,0 +1,319 @@
+package core
+
+import (
+	"fmt"
+	"ledger/common"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ParamType int
+
+const (
+	ParamString ParamType = iota
+	ParamAmount
+	ParamAccount
+	ParamDate
+	ParamEnum
+	ParamList
+)
+
+var paramTypes = []string{"string", "amount", "account", "date", "enum", "list"}
+
+func (p ParamType) String() string {
+	if int(p) < len(paramTypes) {
+		return paramTypes[p]
+	}
+	return fmt.Sprintf("ParamType(%d)", int(p))
+}
+
+func (p ParamType) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + p.String() + `"`), nil
+}
+
+func (p *ParamType) UnmarshalJSON(b []byte) error {
+	for i, name := range paramTypes {
+		if string(b) == `"`+name+`"` {
+			*p = ParamType(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid parameter type: %s", string(b))
+}
+
+// ParamSpec declares a parameter of a transaction template. Amounts are
+// decimals, accounts are keys of existing accounts, dates are written as
+// 2006-01-02, enums take one of Values and lists are rows whose fields are
+// declared by Fields.
+type ParamSpec struct {
+	Name     string      `json:"name"`
+	Type     ParamType   `json:"type"`
+	Required bool        `json:"required,omitempty"`
+	Default  string      `json:"default,omitempty"` // used when an optional parameter is omitted
+	Values   []string    `json:"values,omitempty"`  // allowed values of an enum
+	Fields   []ParamSpec `json:"fields,omitempty"`  // fields of the rows of a list
+}
+
+// check reports why value is not a valid value of p.
+func (p ParamSpec) check(store Store, value string) error {
+	switch p.Type {
+	case ParamAmount:
+		_, err := common.ParseDecimal(value)
+		return err
+	case ParamAccount:
+		_, err := store.Account(value)
+		return err
+	case ParamDate:
+		_, err := time.Parse(time.DateOnly, value)
+		return err
+	case ParamEnum:
+		for _, allowed := range p.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(p.Values, ", "))
+	}
+	return nil
+}
+
+// ParamsError describes every way the parameters of an input fail the
+// schema of its template.
+type ParamsError struct {
+	Type      string
+	Missing   []string         // required parameters that were not given
+	Extra     []string         // parameters the template does not declare
+	Malformed map[string]error // parameters whose value does not fit their type
+}
+
+func (e *ParamsError) Error() string {
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Extra) > 0 {
+		problems = append(problems, "unexpected "+strings.Join(e.Extra, ", "))
+	}
+	names := make([]string, 0, len(e.Malformed))
+	for name := range e.Malformed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems, fmt.Sprintf("%s: %v", name, e.Malformed[name]))
+	}
+	return fmt.Sprintf("parameters of %s: %s", e.Type, strings.Join(problems, "; "))
+}
+
+// resolveParams checks params and lists against the declared parameters of
+// tt and returns them with defaults filled in. Templates that declare no
+// parameters accept any.
+func (tt *TransactionTemplate) resolveParams(store Store, params map[string]string, lists map[string][]map[string]string) (map[string]string, map[string][]map[string]string, error) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	clashes := tt.nameClashes(names)
+	if len(tt.Parameters) == 0 {
+		if len(clashes) > 0 {
+			return nil, nil, &ParamsError{Type: tt.Type, Malformed: clashes}
+		}
+		return params, lists, nil
+	}
+
+	paramsErr := &ParamsError{Type: tt.Type, Malformed: clashes}
+	resolved := resolveFields(store, tt.Parameters, params, "", paramsErr)
+	resolvedLists := make(map[string][]map[string]string)
+	declared := make(map[string]bool, len(tt.Parameters))
+	for _, spec := range tt.Parameters {
+		declared[spec.Name] = true
+		if spec.Type != ParamList {
+			if _, exists := lists[spec.Name]; exists {
+				paramsErr.Malformed[spec.Name] = fmt.Errorf("expected a %s, not a list", spec.Type)
+			}
+			continue
+		}
+		rows, exists := lists[spec.Name]
+		if !exists && spec.Required {
+			paramsErr.Missing = append(paramsErr.Missing, spec.Name)
+		}
+		resolvedRows := make([]map[string]string, len(rows))
+		for i, row := range rows {
+			if len(spec.Fields) == 0 {
+				resolvedRows[i] = row
+				continue
+			}
+			resolvedRows[i] = resolveFields(store, spec.Fields, row, fmt.Sprintf("%s[%d].", spec.Name, i), paramsErr)
+		}
+		resolvedLists[spec.Name] = resolvedRows
+	}
+	for name := range lists {
+		if !declared[name] {
+			paramsErr.Extra = append(paramsErr.Extra, name)
+		}
+	}
+	sort.Strings(paramsErr.Missing)
+	sort.Strings(paramsErr.Extra)
+
+	if len(paramsErr.Missing)+len(paramsErr.Extra)+len(paramsErr.Malformed) > 0 {
+		return nil, nil, paramsErr
+	}
+	return resolved, resolvedLists, nil
+}
+
+// resolveFields checks the scalar values against specs, recording problems
+// in paramsErr under prefix+name, and returns them with defaults filled in.
+func resolveFields(store Store, specs []ParamSpec, values map[string]string, prefix string, paramsErr *ParamsError) map[string]string {
+	resolved := make(map[string]string, len(specs))
+	declared := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		declared[spec.Name] = true
+		value, exists := values[spec.Name]
+		if spec.Type == ParamList {
+			if exists {
+				paramsErr.Malformed[prefix+spec.Name] = fmt.Errorf("expected a list")
+			}
+			continue
+		}
+		if !exists {
+			if spec.Required {
+				paramsErr.Missing = append(paramsErr.Missing, prefix+spec.Name)
+			} else if spec.Default != "" {
+				resolved[spec.Name] = spec.Default
+			}
+			continue
+		}
+		if err := spec.check(store, value); err != nil {
+			paramsErr.Malformed[prefix+spec.Name] = err
+			continue
+		}
+		resolved[spec.Name] = value
+	}
+	for name := range values {
+		if !declared[name] {
+			paramsErr.Extra = append(paramsErr.Extra, prefix+name)
+		}
+	}
+	return resolved
+}
+
+// validateParams reports problems with the parameter declarations of tt and
+// with parameters referenced by its lines but not declared.
+func (tt *TransactionTemplate) validateParams(store Store, referenced []string) []error {
+	if len(tt.Parameters) == 0 {
+		return nil
+	}
+	problems := validateSpecs(store, tt.Parameters, "")
+	names := make([]string, 0, len(tt.Parameters))
+	for _, spec := range tt.Parameters {
+		if spec.Type != ParamList {
+			names = append(names, spec.Name)
+		}
+	}
+	clashes := tt.nameClashes(names)
+	sort.Strings(names)
+	for _, name := range names {
+		if err, exists := clashes[name]; exists {
+			problems = append(problems, fmt.Errorf("parameter %s: %w", name, err))
+		}
+	}
+	declared := make(map[string]bool, len(tt.Parameters))
+	lists := make(map[string]ParamSpec)
+	for _, spec := range tt.Parameters {
+		declared[spec.Name] = true
+		if spec.Type == ParamList {
+			lists[spec.Name] = spec
+			problems = append(problems, validateSpecs(store, spec.Fields, spec.Name+".")...)
+			for _, field := range spec.Fields {
+				if field.Type == ParamList {
+					problems = append(problems, fmt.Errorf("list %s cannot hold the list %s", spec.Name, field.Name))
+				}
+			}
+		}
+	}
+	for _, name := range referenced {
+		if list, field, isField := strings.Cut(name, "."); isField {
+			if spec, exists := lists[list]; exists {
+				if len(spec.Fields) > 0 && !hasSpec(spec.Fields, field) {
+					problems = append(problems, fmt.Errorf("field %s of list %s is used but not declared", field, list))
+				}
+				continue
+			}
+		}
+		if !declared[name] {
+			problems = append(problems, fmt.Errorf("parameter %s is used but not declared", name))
+		}
+	}
+	return problems
+}
+
+// nameClashes reports the scalar parameter names that lines could not tell
+// apart from other data: a name equal to the alias of a repeated line, or to
+// the part before a dot of another name, would be shadowed by the fields
+// nested under it.
+func (tt *TransactionTemplate) nameClashes(names []string) map[string]error {
+	shadowed := make(map[string]error)
+	for i := range tt.LedgerEntriesTemplate {
+		line := &tt.LedgerEntriesTemplate[i]
+		if line.Repeat != "" {
+			shadowed[line.alias()] = fmt.Errorf("clashes with the rows of %s on line %s", line.Repeat, line.Key)
+		}
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		parts := strings.Split(name, ".")
+		for i := 1; i < len(parts); i++ {
+			prefix := strings.Join(parts[:i], ".")
+			if _, exists := shadowed[prefix]; !exists {
+				shadowed[prefix] = fmt.Errorf("clashes with %s", name)
+			}
+		}
+	}
+	clashes := make(map[string]error)
+	for _, name := range names {
+		if err, exists := shadowed[name]; exists {
+			clashes[name] = err
+		}
+	}
+	return clashes
+}
+
+func hasSpec(specs []ParamSpec, name string) bool {
+	for _, spec := range specs {
+		if spec.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSpecs reports problems with the declarations of specs, naming them
+// with prefix.
+func validateSpecs(store Store, specs []ParamSpec, prefix string) []error {
+	var problems []error
+	declared := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		name := prefix + spec.Name
+		if spec.Name == "" {
+			problems = append(problems, fmt.Errorf("parameter without a name"))
+			continue
+		}
+		if declared[spec.Name] {
+			problems = append(problems, fmt.Errorf("parameter %s is declared twice", name))
+		}
+		declared[spec.Name] = true
+		if spec.Type == ParamEnum && len(spec.Values) == 0 {
+			problems = append(problems, fmt.Errorf("enum parameter %s has no values", name))
+		}
+		if spec.Default != "" {
+			if spec.Required {
+				problems = append(problems, fmt.Errorf("required parameter %s has a default", name))
+			} else if err := spec.check(store, spec.Default); err != nil {
+				problems = append(problems, fmt.Errorf("default of parameter %s: %w", name, err))
+			}
+		}
+	}
+	return problems
+}
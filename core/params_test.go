@@ -0,0 +1,122 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"ledger/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const typedTemplateJson = `{
+	"type": "sell_something",
+	"currency": "{{.currency}}",
+	"parameters": [
+		{"name": "sales_before_tax", "type": "amount", "required": true},
+		{"name": "tax_payable", "type": "amount", "default": "0"},
+		{"name": "user_account", "type": "account", "required": true},
+		{"name": "currency", "type": "enum", "values": ["USD", "EUR"], "default": "USD"},
+		{"name": "sold_on", "type": "date"},
+		{"name": "memo", "type": "string"}
+	],
+	"lines": [
+		{"key": "sales_to_bank", "account": "sales_to_bank", "amount": "{{.sales_before_tax}} + {{.tax_payable}}", "direction": "Debit"},
+		{"key": "user_account", "account": "{{.user_account}}", "amount": "{{.sales_before_tax}}", "direction": "Credit"},
+		{"key": "tax_payable", "account": "tax_payable", "amount": "{{.tax_payable}}", "direction": "Credit"}
+	]
+}`
+
+func TestTemplateParameters(t *testing.T) {
+	ledger := loadAccounts()
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(typedTemplateJson))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+
+	transaction, err := ledger.CreateTransaction(TransactionInput{
+		Type: "sell_something",
+		Parameters: map[string]string{
+			"sales_before_tax": "100.25",
+			"user_account":     "user123",
+			"sold_on":          "2024-02-29",
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "100.25 USD", transaction.entries[0].Amount.String())
+	assert.Equal(t, "0.00 USD", transaction.entries[2].Amount.String())
+
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type: "sell_something",
+		Parameters: map[string]string{
+			"tax_payable":  "ten",
+			"user_account": "nobody",
+			"currency":     "GBP",
+			"sold_on":      "2024-02-30",
+			"discount":     "5",
+		},
+	})
+	var paramsErr *ParamsError
+	assert.True(t, errors.As(err, &paramsErr))
+	assert.Equal(t, []string{"sales_before_tax"}, paramsErr.Missing)
+	assert.Equal(t, []string{"discount"}, paramsErr.Extra)
+	assert.Equal(t, 4, len(paramsErr.Malformed))
+	assert.True(t, errors.Is(paramsErr.Malformed["user_account"], ErrAccountNotFound))
+	assert.Contains(t, paramsErr.Malformed, "tax_payable")
+	assert.Contains(t, paramsErr.Malformed, "currency")
+	assert.Contains(t, paramsErr.Malformed, "sold_on")
+}
+
+func TestValidateTemplateParameters(t *testing.T) {
+	ledger := loadAccounts()
+	tt := &TransactionTemplate{}
+	assert.Nil(t, json.Unmarshal([]byte(typedTemplateJson), tt))
+	tt.Parameters = append(tt.Parameters[:1],
+		ParamSpec{Name: "tax_payable", Type: ParamAmount, Default: "abc"},
+		ParamSpec{Name: "currency", Type: ParamEnum},
+		ParamSpec{Name: "currency", Type: ParamString, Required: true, Default: "USD"},
+	)
+
+	_, err := ValidateTemplate(tt, ledger.Store())
+	var templateErr *TemplateError
+	assert.True(t, errors.As(err, &templateErr))
+	// bad default, enum without values, duplicate, required with default,
+	// and user_account used but not declared.
+	assert.Equal(t, 5, len(templateErr.Problems))
+
+	err = json.Unmarshal([]byte(`{"name": "x", "type": "money"}`), &ParamSpec{})
+	assert.NotNil(t, err)
+}
+
+func TestParameterNameClashes(t *testing.T) {
+	ledger := newTestLedger(t, "cash", "sales")
+	tt := &TransactionTemplate{
+		Type: "clash",
+		Parameters: []ParamSpec{
+			{Name: "a", Type: ParamString},
+			{Name: "a.b", Type: ParamString},
+			{Name: "item", Type: ParamAmount},
+			{Name: "rows", Type: ParamList},
+		},
+		LedgerEntriesTemplate: []EntryTemplate{
+			{Key: "cash", AccountKey: "cash", Amount: "{{.item.amount}}", Direction: common.Debit, Repeat: "rows"},
+			{Key: "sales", AccountKey: "sales", Amount: "{{.item.amount}}", Direction: common.Credit, Repeat: "rows"},
+		},
+	}
+	_, err := ValidateTemplate(tt, ledger.Store())
+	var templateErr *TemplateError
+	assert.True(t, errors.As(err, &templateErr))
+	assert.Contains(t, err.Error(), "parameter a: clashes with a.b")
+	assert.Contains(t, err.Error(), "parameter item: clashes with the rows of rows")
+
+	// Templates without declarations check the names they are given.
+	tt.Parameters = nil
+	_, err = tt.CreateTransaction(ledger.Store(), TransactionInput{
+		Parameters: map[string]string{"a": "x", "a.b": "y", "item": "1"},
+		Lists:      map[string][]map[string]string{"rows": {{"amount": "1"}}},
+	})
+	var paramsErr *ParamsError
+	assert.True(t, errors.As(err, &paramsErr))
+	assert.Equal(t, 2, len(paramsErr.Malformed))
+	assert.Contains(t, paramsErr.Malformed, "a")
+	assert.Contains(t, paramsErr.Malformed, "item")
+}
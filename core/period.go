@@ -0,0 +1,268 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrPeriodClosed  = errors.New("period is closed")
+	ErrPeriodOpen    = errors.New("period is open")
+	ErrPeriodOverlap = errors.New("period overlaps another")
+	ErrPeriodRunning = errors.New("period has not ended")
+)
+
+// Period is an accounting period, such as a month, running from Start up to
+// but not including End. Once closed, transactions effective inside it are
+// rejected; corrections are posted as adjusting transactions in a later
+// period instead.
+type Period struct {
+	Name    string        `json:"name"`
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Closed  bool          `json:"closed,omitempty"`
+	History []PeriodEvent `json:"history,omitempty"` // closes and reopens, oldest first
+}
+
+// PeriodEvent records a close or reopen of a period.
+type PeriodEvent struct {
+	Action   string           `json:"action"` // close or reopen
+	At       time.Time        `json:"at"`
+	Reason   string           `json:"reason,omitempty"`   // why a period was reopened or closed early
+	Balances []ClosingBalance `json:"balances,omitempty"` // snapshot taken by a close
+}
+
+// ClosingBalance is the own balance of an account in one currency at the end
+// of a period.
+type ClosingBalance struct {
+	Account string  `json:"account"`
+	Balance Balance `json:"balance"`
+}
+
+// ClosingBalances returns the balances snapshotted when p was last closed, or
+// nil while p is open.
+func (p *Period) ClosingBalances() []ClosingBalance {
+	if !p.Closed {
+		return nil
+	}
+	for i := len(p.History) - 1; i >= 0; i-- {
+		if p.History[i].Action == "close" {
+			return p.History[i].Balances
+		}
+	}
+	return nil
+}
+
+func (p *Period) contains(at time.Time) bool {
+	return !at.Before(p.Start) && at.Before(p.End)
+}
+
+// AddPeriod adds an open accounting period. Periods must have a name and may
+// not overlap.
+func (l *Ledger) AddPeriod(period *Period) error {
+	if period.Name == "" {
+		return errors.New("period has no name")
+	}
+	if !period.Start.Before(period.End) {
+		return fmt.Errorf("period %s: start must be before end", period.Name)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.store.Period(period.Name); err == nil {
+		return fmt.Errorf("period %s already exists", period.Name)
+	}
+	periods, err := l.store.Periods()
+	if err != nil {
+		return err
+	}
+	for _, other := range periods {
+		if period.Start.Before(other.End) && other.Start.Before(period.End) {
+			return fmt.Errorf("%w: %s and %s", ErrPeriodOverlap, period.Name, other.Name)
+		}
+	}
+	return l.putPeriod(&Period{Name: period.Name, Start: period.Start.UTC(), End: period.End.UTC()})
+}
+
+// Period returns the accounting period with the given name.
+func (l *Ledger) Period(name string) (*Period, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.store.Period(name)
+}
+
+// Periods returns the accounting periods in order of their start.
+func (l *Ledger) Periods() ([]*Period, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.store.Periods()
+}
+
+// ClosePeriod snapshots the balance of every account at the end of a period
+// and locks it, so that transactions effective inside it are rejected with
+// ErrPeriodClosed. A period that has not ended yet fails with
+// ErrPeriodRunning.
+func (l *Ledger) ClosePeriod(name string) (*Period, error) {
+	return l.closePeriod(name, "", false)
+}
+
+// ForceClosePeriod closes a period like ClosePeriod, even before it has
+// ended. The reason is kept in its history.
+func (l *Ledger) ForceClosePeriod(name string, reason string) (*Period, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("period %s: closing early needs a reason", name)
+	}
+	return l.closePeriod(name, reason, true)
+}
+
+func (l *Ledger) closePeriod(name string, reason string, force bool) (*Period, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	period, err := l.store.Period(name)
+	if err != nil {
+		return nil, err
+	}
+	if period.Closed {
+		return nil, fmt.Errorf("period %s: %w", name, ErrPeriodClosed)
+	}
+	now := l.now().UTC()
+	if !force && now.Before(period.End) {
+		return nil, fmt.Errorf("period %s: %w: it ends at %s", name, ErrPeriodRunning, period.End.Format(time.RFC3339))
+	}
+	balances, err := l.closingBalances(period.End.Add(-time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+	closed := period.with(PeriodEvent{Action: "close", At: now, Reason: reason, Balances: balances})
+	closed.Closed = true
+	if err := l.putPeriod(closed); err != nil {
+		return nil, err
+	}
+	return closed, nil
+}
+
+// ReopenPeriod unlocks a closed period. The reason is kept in its history
+// together with the snapshot of the close it undoes.
+func (l *Ledger) ReopenPeriod(name string, reason string) (*Period, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("period %s: reopening needs a reason", name)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	period, err := l.store.Period(name)
+	if err != nil {
+		return nil, err
+	}
+	if !period.Closed {
+		return nil, fmt.Errorf("period %s: %w", name, ErrPeriodOpen)
+	}
+	reopened := period.with(PeriodEvent{Action: "reopen", At: l.now().UTC(), Reason: reason})
+	reopened.Closed = false
+	if err := l.putPeriod(reopened); err != nil {
+		return nil, err
+	}
+	return reopened, nil
+}
+
+// PostAdjustment posts a transaction that corrects a closed period. It has to
+// be effective after the period, by default when it is posted, and is listed
+// by Adjustments.
+func (l *Ledger) PostAdjustment(periodName string, transaction *Transaction) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	period, err := l.store.Period(periodName)
+	if err != nil {
+		return err
+	}
+	if !period.Closed {
+		return fmt.Errorf("period %s: %w: post corrections to it directly", periodName, ErrPeriodOpen)
+	}
+	effectiveAt := transaction.effectiveAt
+	if effectiveAt.IsZero() {
+		effectiveAt = l.now()
+	}
+	if effectiveAt.Before(period.End) {
+		return fmt.Errorf("period %s: adjustment must be effective after the period, not at %s", periodName, effectiveAt.Format(time.RFC3339))
+	}
+	transaction.adjusts = periodName
+	if err := l.post(transaction, newAccountCreator(l.store, false)); err != nil {
+		transaction.adjusts = ""
+		return err
+	}
+	return nil
+}
+
+// Adjustments returns the adjusting transactions posted for a period, in the
+// order they were posted.
+func (l *Ledger) Adjustments(periodName string) ([]*Transaction, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, err := l.store.Period(periodName); err != nil {
+		return nil, err
+	}
+	transactions, err := l.store.Transactions()
+	if err != nil {
+		return nil, err
+	}
+	var adjustments []*Transaction
+	for _, transaction := range transactions {
+		if transaction.adjusts == periodName {
+			adjustments = append(adjustments, transaction)
+		}
+	}
+	return adjustments, nil
+}
+
+// checkPeriod rejects effective times inside a closed period.
+func (l *Ledger) checkPeriod(effectiveAt time.Time) error {
+	periods, err := l.store.Periods()
+	if err != nil {
+		return err
+	}
+	for _, period := range periods {
+		if period.Closed && period.contains(effectiveAt) {
+			return fmt.Errorf("period %s: %w: transaction is effective at %s", period.Name, ErrPeriodClosed, effectiveAt.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// closingBalances returns the own balance of every account as of at, sorted
+// by account key and currency code.
+func (l *Ledger) closingBalances(at time.Time) ([]ClosingBalance, error) {
+	accounts, err := l.store.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	var balances []ClosingBalance
+	for _, account := range accounts {
+		var accountBalances []ClosingBalance
+		for _, history := range l.history[account.Key] {
+			if balance, exists := history.asOf(at); exists {
+				accountBalances = append(accountBalances, ClosingBalance{Account: account.Key, Balance: balance.balance(account.Type.NormalBalance())})
+			}
+		}
+		sort.Slice(accountBalances, func(i, j int) bool {
+			return accountBalances[i].Balance.Posted.Currency.Code < accountBalances[j].Balance.Posted.Currency.Code
+		})
+		balances = append(balances, accountBalances...)
+	}
+	return balances, nil
+}
+
+// with returns a copy of p with event added to its history.
+func (p *Period) with(event PeriodEvent) *Period {
+	updated := *p
+	updated.History = append(append([]PeriodEvent(nil), p.History...), event)
+	return &updated
+}
+
+func (l *Ledger) putPeriod(period *Period) error {
+	if l.journal != nil {
+		if err := l.journal.Append(journalRecord{Period: period}); err != nil {
+			return err
+		}
+	}
+	return l.store.PutPeriod(period)
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	for _, key := range []string{"bank", "income"} {
+		_, err := ledger.CreateAccount(&AccountTemplate{Key: key})
+		assert.Nil(t, err)
+	}
+	ledger.now = func() time.Time { return date(time.April, 10) }
+
+	assert.Nil(t, ledger.AddPeriod(&Period{Name: "2024-03", Start: date(time.March, 1), End: date(time.April, 1)}))
+	assert.Nil(t, ledger.AddPeriod(&Period{Name: "2024-04", Start: date(time.April, 1), End: date(time.May, 1)}))
+	err := ledger.AddPeriod(&Period{Name: "march", Start: date(time.March, 15), End: date(time.April, 15)})
+	assert.True(t, errors.Is(err, ErrPeriodOverlap))
+
+	postAt(t, ledger, date(time.March, 5), "bank", "income", "USD", "100.00")
+	postAt(t, ledger, date(time.April, 2), "bank", "income", "USD", "50.00")
+
+	march, err := ledger.ClosePeriod("2024-03")
+	assert.Nil(t, err)
+	assert.True(t, march.Closed)
+	closing := march.ClosingBalances()
+	assert.Equal(t, 2, len(closing))
+	assert.Equal(t, "bank", closing[0].Account)
+	assert.Equal(t, "100.00 USD", closing[0].Balance.Posted.String())
+	assert.Equal(t, "income", closing[1].Account)
+	assert.Equal(t, "-100.00 USD", closing[1].Balance.Posted.String())
+	_, err = ledger.ClosePeriod("2024-03")
+	assert.True(t, errors.Is(err, ErrPeriodClosed))
+
+	// Transactions effective in March are rejected, corrections go into April.
+	err = ledger.Post(transactionAt(t, ledger, date(time.March, 20), "bank", "income", "USD", "20.00"))
+	assert.True(t, errors.Is(err, ErrPeriodClosed))
+	err = ledger.PostAdjustment("2024-04", transactionAt(t, ledger, time.Time{}, "bank", "income", "USD", "20.00"))
+	assert.True(t, errors.Is(err, ErrPeriodOpen))
+	adjustment := transactionAt(t, ledger, time.Time{}, "bank", "income", "USD", "20.00")
+	assert.Nil(t, ledger.PostAdjustment("2024-03", adjustment))
+	assert.Equal(t, "2024-03", adjustment.Adjusts())
+	assert.Equal(t, date(time.April, 10), adjustment.EffectiveAt())
+	adjustments, err := ledger.Adjustments("2024-03")
+	assert.Nil(t, err)
+	assert.Equal(t, []*Transaction{adjustment}, adjustments)
+
+	_, err = ledger.ReopenPeriod("2024-03", "")
+	assert.NotNil(t, err)
+	_, err = ledger.ReopenPeriod("2024-04", "no reason")
+	assert.True(t, errors.Is(err, ErrPeriodOpen))
+	march, err = ledger.ReopenPeriod("2024-03", "late invoice")
+	assert.Nil(t, err)
+	assert.False(t, march.Closed)
+	assert.Nil(t, march.ClosingBalances())
+	assert.Equal(t, 2, len(march.History))
+	assert.Equal(t, "reopen", march.History[1].Action)
+	assert.Equal(t, "late invoice", march.History[1].Reason)
+	postAt(t, ledger, date(time.March, 20), "bank", "income", "USD", "20.00")
+
+	// The periods survive a reopen of the journal, and so does the lock.
+	ledger = reopen(t, ledger, path)
+	ledger.now = func() time.Time { return date(time.April, 11) }
+	march, err = ledger.ClosePeriod("2024-03")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(march.History))
+	assert.Equal(t, "120.00 USD", march.ClosingBalances()[0].Balance.Posted.String())
+	err = ledger.Post(transactionAt(t, ledger, date(time.March, 31), "bank", "income", "USD", "1.00"))
+	assert.True(t, errors.Is(err, ErrPeriodClosed))
+	adjustments, err = ledger.Adjustments("2024-03")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(adjustments))
+	periods, err := ledger.Periods()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(periods))
+	assert.Equal(t, "2024-03", periods[0].Name)
+}
+
+// periodLedger returns a ledger with March and April 2024 as periods, at
+// April 10.
+func periodLedger(t *testing.T) *Ledger {
+	ledger := newTestLedger(t, "bank", "income")
+	ledger.now = func() time.Time { return date(time.April, 10) }
+	assert.Nil(t, ledger.AddPeriod(&Period{Name: "2024-03", Start: date(time.March, 1), End: date(time.April, 1)}))
+	assert.Nil(t, ledger.AddPeriod(&Period{Name: "2024-04", Start: date(time.April, 1), End: date(time.May, 1)}))
+	return ledger
+}
+
+func TestClosePeriodBeforeItEnds(t *testing.T) {
+	ledger := periodLedger(t)
+	_, err := ledger.ClosePeriod("2024-04")
+	assert.True(t, errors.Is(err, ErrPeriodRunning))
+	_, err = ledger.ForceClosePeriod("2024-04", "")
+	assert.NotNil(t, err)
+	april, err := ledger.Period("2024-04")
+	assert.Nil(t, err)
+	assert.False(t, april.Closed)
+
+	april, err = ledger.ForceClosePeriod("2024-04", "audit")
+	assert.Nil(t, err)
+	assert.True(t, april.Closed)
+	assert.Equal(t, "audit", april.History[0].Reason)
+}
+
+func TestPostIntoClosedPeriod(t *testing.T) {
+	ledger := periodLedger(t)
+	postAt(t, ledger, date(time.March, 31), "bank", "income", "USD", "10.00")
+	_, err := ledger.ClosePeriod("2024-03")
+	assert.Nil(t, err)
+
+	// Its first and last moment are closed, the periods around it are not.
+	for _, at := range []time.Time{date(time.March, 1), date(time.April, 1).Add(-time.Nanosecond)} {
+		err = ledger.Post(transactionAt(t, ledger, at, "bank", "income", "USD", "1.00"))
+		assert.True(t, errors.Is(err, ErrPeriodClosed), at)
+	}
+	postAt(t, ledger, date(time.April, 1), "bank", "income", "USD", "1.00")
+	postAt(t, ledger, date(time.February, 28), "bank", "income", "USD", "1.00")
+
+	// Nor can a closed period be reached through the effective date of an
+	// input.
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(`{
+		"type": "deposit",
+		"currency": "USD",
+		"lines": [
+			{"key": "bank", "account": "bank", "amount": "{{.amount}}", "direction": "Debit"},
+			{"key": "income", "account": "income", "amount": "{{.amount}}", "direction": "Credit"}
+		]
+	}`))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+	_, err = ledger.CreateTransaction(TransactionInput{Type: "deposit", EffectiveDate: "2024-03-15", Parameters: map[string]string{"amount": "1.00"}})
+	assert.True(t, errors.Is(err, ErrPeriodClosed))
+	balance, err := ledger.Balance("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "12.00 USD", balance.Posted.String())
+}
+
+func TestReopenPeriod(t *testing.T) {
+	ledger := periodLedger(t)
+	_, err := ledger.ClosePeriod("2024-03")
+	assert.Nil(t, err)
+	_, err = ledger.ReopenPeriod("missing", "typo")
+	assert.True(t, errors.Is(err, ErrPeriodNotFound))
+	_, err = ledger.ReopenPeriod("2024-03", "late invoice")
+	assert.Nil(t, err)
+
+	// Closing again takes a new snapshot, and the history keeps every step.
+	march, err := ledger.ClosePeriod("2024-03")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"close", "reopen", "close"}, []string{march.History[0].Action, march.History[1].Action, march.History[2].Action})
+	assert.Nil(t, march.History[0].Balances)
+}
+
+func TestPostAdjustment(t *testing.T) {
+	ledger := periodLedger(t)
+	postAt(t, ledger, date(time.March, 5), "bank", "income", "USD", "100.00")
+	_, err := ledger.ClosePeriod("2024-03")
+	assert.Nil(t, err)
+	err = ledger.PostAdjustment("missing", transactionAt(t, ledger, time.Time{}, "bank", "income", "USD", "5.00"))
+	assert.True(t, errors.Is(err, ErrPeriodNotFound))
+
+	// They must fall after the period.
+	early := transactionAt(t, ledger, date(time.March, 31), "bank", "income", "USD", "5.00")
+	assert.NotNil(t, ledger.PostAdjustment("2024-03", early))
+	assert.Equal(t, "", early.Adjusts())
+	dated := transactionAt(t, ledger, date(time.April, 2), "bank", "income", "USD", "5.00")
+	assert.Nil(t, ledger.PostAdjustment("2024-03", dated))
+	undated := transactionAt(t, ledger, time.Time{}, "income", "bank", "USD", "3.00")
+	assert.Nil(t, ledger.PostAdjustment("2024-03", undated))
+
+	adjustments, err := ledger.Adjustments("2024-03")
+	assert.Nil(t, err)
+	assert.Equal(t, []*Transaction{dated, undated}, adjustments)
+	adjustments, err = ledger.Adjustments("2024-04")
+	assert.Nil(t, err)
+	assert.Nil(t, adjustments)
+
+	// The closed period keeps its snapshot, the adjustments count from April.
+	march, err := ledger.Period("2024-03")
+	assert.Nil(t, err)
+	assert.Equal(t, "100.00 USD", march.ClosingBalances()[0].Balance.Posted.String())
+	balance, err := ledger.BalanceAsOf("bank", date(time.April, 30))
+	assert.Nil(t, err)
+	assert.Equal(t, "102.00 USD", balance.Posted.String())
+}
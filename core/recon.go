@@ -0,0 +1,382 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ledger/common"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+var (
+	ErrAlreadyReconciled = errors.New("already reconciled")
+	ErrReconMismatch     = errors.New("entry does not fit external record")
+)
+
+// ReconStatus tells how much of an external record or entry is linked.
+type ReconStatus int
+
+const (
+	Unmatched ReconStatus = iota
+	PartiallyMatched
+	Matched
+)
+
+var reconStatusNames = []string{"unmatched", "partially_matched", "matched"}
+
+func (s ReconStatus) String() string {
+	if s >= 0 && int(s) < len(reconStatusNames) {
+		return reconStatusNames[s]
+	}
+	return fmt.Sprintf("ReconStatus(%d)", int(s))
+}
+
+func (s ReconStatus) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+func (s *ReconStatus) UnmarshalJSON(b []byte) error {
+	for i, name := range reconStatusNames {
+		if string(b) == `"`+name+`"` {
+			*s = ReconStatus(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid reconciliation status: %s", string(b))
+}
+
+// ExternalRecord is an item kept outside the ledger, such as a bank statement
+// line or a PSP payout, that the entries of Account are reconciled against.
+// Direction is the side of Account the item belongs on: money arriving on a
+// bank statement is a Debit of the bank account.
+type ExternalRecord struct {
+	ID           string           `json:"id"`
+	Account      string           `json:"account"`
+	Amount       common.Money     `json:"amount"`
+	Direction    common.Direction `json:"direction"`
+	ValueDate    time.Time        `json:"value_date"`
+	Reference    string           `json:"reference,omitempty"`
+	Counterparty string           `json:"counterparty,omitempty"`
+	Source       string           `json:"source,omitempty"` // statement or system the record came from
+	// Tolerance is how far the linked amount may differ from Amount for the
+	// record to count as matched, as a decimal in the record's currency.
+	Tolerance string `json:"tolerance,omitempty"`
+}
+
+func (record *ExternalRecord) tolerance() common.Money {
+	if record.Tolerance == "" {
+		return common.Zero(record.Amount.Currency)
+	}
+	tolerance, _ := common.ParseMoney(record.Tolerance, record.Amount.Currency.Code)
+	return tolerance
+}
+
+// ReconLink allocates part of an entry to an external record.
+type ReconLink struct {
+	Record string       `json:"record"`
+	Entry  string       `json:"entry"`
+	Amount common.Money `json:"amount"`
+}
+
+// RecordState is the reconciliation state of an external record.
+type RecordState struct {
+	Record    *ExternalRecord
+	Status    ReconStatus
+	Matched   common.Money // linked so far
+	Remaining common.Money // Amount less Matched
+	Links     []ReconLink
+}
+
+// EntryState is the reconciliation state of an entry.
+type EntryState struct {
+	Entry     Entries
+	Status    ReconStatus
+	Matched   common.Money
+	Remaining common.Money
+	Links     []ReconLink
+}
+
+// ReconReport lists what is left to reconcile on an account.
+type ReconReport struct {
+	Account string
+	Records []RecordState // records that are not matched
+	Entries []EntryState  // posted entries that are not matched
+}
+
+// AddExternalRecord registers an external record for reconciliation. A record
+// without an ID gets a generated one.
+func (l *Ledger) AddExternalRecord(record *ExternalRecord) error {
+	if record.Amount.Units == nil || record.Amount.Sign() <= 0 {
+		return fmt.Errorf("external record %s: amount must be positive", record.ID)
+	}
+	if record.Tolerance != "" {
+		tolerance, err := common.ParseMoney(record.Tolerance, record.Amount.Currency.Code)
+		if err != nil {
+			return fmt.Errorf("external record %s: tolerance: %w", record.ID, err)
+		}
+		if tolerance.Sign() < 0 {
+			return fmt.Errorf("external record %s: tolerance must not be negative", record.ID)
+		}
+	}
+	if record.ID == "" {
+		record.ID = xid.New().String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.store.Account(record.Account); err != nil {
+		return fmt.Errorf("external record %s: %w", record.ID, err)
+	}
+	if _, err := l.store.ExternalRecord(record.ID); err == nil {
+		return fmt.Errorf("%w: %s", ErrRecordExists, record.ID)
+	}
+	if l.journal != nil {
+		if err := l.journal.Append(journalRecord{ExternalRecord: record}); err != nil {
+			return err
+		}
+	}
+	return l.store.PutExternalRecord(record)
+}
+
+// Link allocates the unmatched part of each of the entries, in order, to the
+// unmatched part of an external record. The entries must be posted to the
+// record's account on its side and in its currency. When what is left of the
+// record and of an entry differ by no more than the record's tolerance, the
+// whole rest of the entry is allocated and both end up matched.
+func (l *Ledger) Link(recordID string, entryIDs ...string) ([]ReconLink, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.link(recordID, entryIDs)
+}
+
+// LinkTransaction links the entries of a transaction that fit an external
+// record, as Link does.
+func (l *Ledger) LinkTransaction(recordID string, transactionID string) ([]ReconLink, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	record, err := l.store.ExternalRecord(recordID)
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := l.store.Transaction(transactionID)
+	if err != nil {
+		return nil, err
+	}
+	var entryIDs []string
+	for _, entry := range transaction.entries {
+		if reconFits(record, entry) == nil {
+			entryIDs = append(entryIDs, entry.id)
+		}
+	}
+	if len(entryIDs) == 0 {
+		return nil, fmt.Errorf("%w: transaction %s has no entry for record %s", ErrReconMismatch, transactionID, recordID)
+	}
+	return l.link(recordID, entryIDs)
+}
+
+func (l *Ledger) link(recordID string, entryIDs []string) ([]ReconLink, error) {
+	record, err := l.store.ExternalRecord(recordID)
+	if err != nil {
+		return nil, err
+	}
+	state, err := l.recordState(record)
+	if err != nil {
+		return nil, err
+	}
+	tolerance := record.tolerance()
+	remaining := state.Remaining
+
+	var links []ReconLink
+	for _, entryID := range entryIDs {
+		if state.Status == Matched || remaining.Sign() <= 0 {
+			return nil, fmt.Errorf("record %s: %w", recordID, ErrAlreadyReconciled)
+		}
+		entry, err := l.store.Entry(entryID)
+		if err != nil {
+			return nil, err
+		}
+		if err := reconFits(record, *entry); err != nil {
+			return nil, err
+		}
+		entryState, err := l.entryState(*entry)
+		if err != nil {
+			return nil, err
+		}
+		entryRemaining := entryState.Remaining
+		for _, link := range links {
+			if link.Entry == entryID {
+				entryRemaining, _ = entryRemaining.Sub(link.Amount)
+			}
+		}
+		if entryRemaining.Sign() <= 0 {
+			return nil, fmt.Errorf("entry %s: %w", entryID, ErrAlreadyReconciled)
+		}
+
+		amount := entryRemaining
+		difference, _ := remaining.Sub(entryRemaining)
+		if difference.Sign() < 0 {
+			difference = difference.Neg()
+		}
+		if difference.Cmp(tolerance) > 0 && remaining.Cmp(entryRemaining) < 0 {
+			amount = remaining
+		}
+		links = append(links, ReconLink{Record: recordID, Entry: entryID, Amount: amount})
+		remaining, _ = remaining.Sub(amount)
+		if difference.Cmp(tolerance) <= 0 {
+			state.Status = Matched
+		}
+	}
+
+	if l.journal != nil {
+		if err := l.journal.Append(journalRecord{Links: links}); err != nil {
+			return nil, err
+		}
+	}
+	for _, link := range links {
+		if err := l.store.PutLink(link); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// reconFits reports why entry cannot be linked to record.
+func reconFits(record *ExternalRecord, entry Entries) error {
+	switch {
+	case entry.Account.Key != record.Account:
+		return fmt.Errorf("%w: entry %s is on account %s, not %s", ErrReconMismatch, entry.id, entry.Account.Key, record.Account)
+	case entry.Amount.Currency != record.Amount.Currency:
+		return fmt.Errorf("%w: entry %s is in %s, not %s", ErrReconMismatch, entry.id, entry.Amount.Currency, record.Amount.Currency)
+	case entry.Direction != record.Direction:
+		return fmt.Errorf("%w: entry %s is a %s, not a %s", ErrReconMismatch, entry.id, entry.Direction, record.Direction)
+	case entry.Status != common.Posted:
+		return fmt.Errorf("%w: entry %s is not posted", ErrReconMismatch, entry.id)
+	}
+	return nil
+}
+
+// Unlink removes every link of an external record.
+func (l *Ledger) Unlink(recordID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.store.ExternalRecord(recordID); err != nil {
+		return err
+	}
+	if l.journal != nil {
+		if err := l.journal.Append(journalRecord{Unlink: recordID}); err != nil {
+			return err
+		}
+	}
+	return l.store.DeleteRecordLinks(recordID)
+}
+
+// RecordState returns the reconciliation state of an external record.
+func (l *Ledger) RecordState(recordID string) (RecordState, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	record, err := l.store.ExternalRecord(recordID)
+	if err != nil {
+		return RecordState{}, err
+	}
+	return l.recordState(record)
+}
+
+// EntryState returns the reconciliation state of an entry.
+func (l *Ledger) EntryState(entryID string) (EntryState, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entry, err := l.store.Entry(entryID)
+	if err != nil {
+		return EntryState{}, err
+	}
+	return l.entryState(*entry)
+}
+
+// Unreconciled reports the external records and posted entries of an account
+// that are not fully matched yet.
+func (l *Ledger) Unreconciled(accountKey string) (*ReconReport, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, err := l.store.Account(accountKey); err != nil {
+		return nil, err
+	}
+	report := &ReconReport{Account: accountKey}
+	records, err := l.store.ExternalRecords(accountKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		state, err := l.recordState(record)
+		if err != nil {
+			return nil, err
+		}
+		if state.Status != Matched {
+			report.Records = append(report.Records, state)
+		}
+	}
+	entries, err := l.store.AccountEntries(accountKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Status != common.Posted {
+			continue
+		}
+		state, err := l.entryState(entry)
+		if err != nil {
+			return nil, err
+		}
+		if state.Status != Matched {
+			report.Entries = append(report.Entries, state)
+		}
+	}
+	return report, nil
+}
+
+func (l *Ledger) recordState(record *ExternalRecord) (RecordState, error) {
+	links, err := l.store.RecordLinks(record.ID)
+	if err != nil {
+		return RecordState{}, err
+	}
+	state := RecordState{Record: record, Links: links}
+	state.Matched, state.Remaining = linkedAmount(record.Amount, links)
+	difference := state.Remaining
+	if difference.Sign() < 0 {
+		difference = difference.Neg()
+	}
+	switch {
+	case len(links) > 0 && difference.Cmp(record.tolerance()) <= 0:
+		state.Status = Matched
+	case len(links) > 0:
+		state.Status = PartiallyMatched
+	}
+	return state, nil
+}
+
+func (l *Ledger) entryState(entry Entries) (EntryState, error) {
+	links, err := l.store.EntryLinks(entry.id)
+	if err != nil {
+		return EntryState{}, err
+	}
+	state := EntryState{Entry: entry, Links: links}
+	state.Matched, state.Remaining = linkedAmount(entry.Amount, links)
+	switch {
+	case state.Remaining.Sign() <= 0:
+		state.Status = Matched
+	case len(links) > 0:
+		state.Status = PartiallyMatched
+	}
+	return state, nil
+}
+
+// linkedAmount sums the links of an amount and returns the sum together with
+// what is left of the amount.
+func linkedAmount(amount common.Money, links []ReconLink) (common.Money, common.Money) {
+	matched := common.Zero(amount.Currency)
+	for _, link := range links {
+		matched, _ = matched.Add(link.Amount)
+	}
+	remaining, _ := amount.Sub(matched)
+	return matched, remaining
+}
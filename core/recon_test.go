@@ -0,0 +1,143 @@
+package core
+
+import (
+	"errors"
+	"ledger/common"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// postDeposit posts amount from income to bank and returns the bank entry.
+func postDeposit(t *testing.T, ledger *Ledger, id string, amount string) Entries {
+	bank, _ := ledger.Account("bank")
+	income, _ := ledger.Account("income")
+	transaction := NewTransaction(
+		*NewEntry(bank, money(t, amount, "USD"), common.Debit, common.Posted),
+		*NewEntry(income, money(t, amount, "USD"), common.Credit, common.Posted),
+	)
+	transaction.id = id
+	assert.Nil(t, ledger.Post(transaction))
+	return transaction.entries[0]
+}
+
+func bankRecord(t *testing.T, id string, amount string) *ExternalRecord {
+	return &ExternalRecord{
+		ID:        id,
+		Account:   "bank",
+		Amount:    money(t, amount, "USD"),
+		Direction: common.Debit,
+		ValueDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Source:    "statement-2024-03",
+	}
+}
+
+func TestReconciliation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	for _, key := range []string{"bank", "income"} {
+		_, err := ledger.CreateAccount(&AccountTemplate{Key: key})
+		assert.Nil(t, err)
+	}
+	postDeposit(t, ledger, "sale-1", "100.00")
+	fifty := postDeposit(t, ledger, "sale-2", "50.00")
+	thirty := postDeposit(t, ledger, "sale-3", "30.00")
+	fee := postDeposit(t, ledger, "sale-4", "20.00")
+	postDeposit(t, ledger, "sale-5", "5.00")
+
+	// One record, one transaction.
+	assert.Nil(t, ledger.AddExternalRecord(bankRecord(t, "line-1", "100.00")))
+	links, err := ledger.LinkTransaction("line-1", "sale-1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(links))
+	state, err := ledger.RecordState("line-1")
+	assert.Nil(t, err)
+	assert.Equal(t, Matched, state.Status)
+
+	// One record, several entries.
+	assert.Nil(t, ledger.AddExternalRecord(bankRecord(t, "line-2", "80.00")))
+	_, err = ledger.Link("line-2", fifty.ID())
+	assert.Nil(t, err)
+	state, err = ledger.RecordState("line-2")
+	assert.Nil(t, err)
+	assert.Equal(t, PartiallyMatched, state.Status)
+	assert.Equal(t, "30.00 USD", state.Remaining.String())
+	_, err = ledger.Link("line-2", thirty.ID())
+	assert.Nil(t, err)
+	state, err = ledger.RecordState("line-2")
+	assert.Nil(t, err)
+	assert.Equal(t, Matched, state.Status)
+
+	_, err = ledger.Link("line-2", fee.ID())
+	assert.True(t, errors.Is(err, ErrAlreadyReconciled))
+	_, err = ledger.LinkTransaction("line-1", "sale-2")
+	assert.True(t, errors.Is(err, ErrAlreadyReconciled))
+
+	// A bank fee of 0.05 is within the tolerance of the record.
+	record := bankRecord(t, "line-3", "19.95")
+	record.Tolerance = "0.05"
+	assert.Nil(t, ledger.AddExternalRecord(record))
+	links, err = ledger.Link("line-3", fee.ID())
+	assert.Nil(t, err)
+	assert.Equal(t, "20.00 USD", links[0].Amount.String())
+	entryState, err := ledger.EntryState(fee.ID())
+	assert.Nil(t, err)
+	assert.Equal(t, Matched, entryState.Status)
+
+	// A record smaller than the entry leaves part of the entry open.
+	assert.Nil(t, ledger.AddExternalRecord(bankRecord(t, "line-4", "2.00")))
+	assert.Nil(t, ledger.AddExternalRecord(bankRecord(t, "line-5", "7.00")))
+	_, err = ledger.LinkTransaction("line-4", "sale-5")
+	assert.Nil(t, err)
+
+	report, err := ledger.Unreconciled("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Records))
+	assert.Equal(t, "line-5", report.Records[0].Record.ID)
+	assert.Equal(t, Unmatched, report.Records[0].Status)
+	assert.Equal(t, 1, len(report.Entries))
+	assert.Equal(t, PartiallyMatched, report.Entries[0].Status)
+	assert.Equal(t, "3.00 USD", report.Entries[0].Remaining.String())
+
+	// Reconciliation survives a restart, and can be undone.
+	ledger = reopen(t, ledger, path)
+	state, err = ledger.RecordState("line-2")
+	assert.Nil(t, err)
+	assert.Equal(t, Matched, state.Status)
+	assert.Equal(t, 2, len(state.Links))
+	assert.Nil(t, ledger.Unlink("line-2"))
+	ledger = reopen(t, ledger, path)
+	report, err = ledger.Unreconciled("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Records))
+	assert.Equal(t, 3, len(report.Entries))
+}
+
+func TestReconciliationMismatch(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income")
+	postDeposit(t, ledger, "sale-1", "10.00")
+	transaction, err := ledger.Store().Transaction("sale-1")
+	assert.Nil(t, err)
+	income := transaction.entries[1]
+
+	assert.Nil(t, ledger.AddExternalRecord(bankRecord(t, "line-1", "10.00")))
+	_, err = ledger.Link("line-1", income.ID())
+	assert.True(t, errors.Is(err, ErrReconMismatch))
+
+	record := bankRecord(t, "line-2", "10.00")
+	record.Direction = common.Credit
+	assert.Nil(t, ledger.AddExternalRecord(record))
+	_, err = ledger.LinkTransaction("line-2", "sale-1")
+	assert.True(t, errors.Is(err, ErrReconMismatch))
+
+	assert.True(t, errors.Is(ledger.AddExternalRecord(bankRecord(t, "line-1", "1.00")), ErrRecordExists))
+	record = bankRecord(t, "", "1.00")
+	record.Account = "missing"
+	assert.True(t, errors.Is(ledger.AddExternalRecord(record), ErrAccountNotFound))
+	assert.NotNil(t, ledger.AddExternalRecord(bankRecord(t, "", "-1.00")))
+
+	_, err = ledger.Link("missing", income.ID())
+	assert.True(t, errors.Is(err, ErrRecordNotFound))
+}
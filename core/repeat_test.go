@@ -0,0 +1,122 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const payoutTemplateJson = `{
+	"type": "payout",
+	"currency": "USD",
+	"parameters": [
+		{"name": "total", "type": "amount", "required": true},
+		{"name": "merchants", "type": "list", "required": true, "fields": [
+			{"name": "account", "type": "account", "required": true},
+			{"name": "amount", "type": "amount", "required": true},
+			{"name": "fee", "type": "amount", "default": "0"}
+		]}
+	],
+	"lines": [
+		{"key": "clearing", "account": "clearing", "amount": "{{.total}}", "direction": "Debit"},
+		{"key": "merchant", "account": "{{.merchant.account}}", "amount": "{{.merchant.amount}} - {{.merchant.fee}}", "direction": "Credit", "repeat": "merchants", "as": "merchant"},
+		{"key": "fee", "account": "fees", "amount": "{{.item.fee}}", "direction": "Credit", "repeat": "merchants", "skip_if_zero": true}
+	]
+}`
+
+const payoutInputJson = `{
+	"type": "payout",
+	"ledger": {"ik": "payout-1"},
+	"parameters": {
+		"total": "100.00",
+		"merchants": [
+			{"account": "merchants/a", "amount": "60.00", "fee": "1.50"},
+			{"account": "merchants/b", "amount": "40.00"}
+		]
+	}
+}`
+
+func TestRepeatedLines(t *testing.T) {
+	ledger := newTestLedger(t, "clearing", "fees", "merchants/a", "merchants/b")
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(payoutTemplateJson))
+	assert.Nil(t, err)
+	params, err := ValidateTemplate(tt, ledger.Store())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"merchants", "merchants.account", "merchants.amount", "merchants.fee", "total"}, params)
+	assert.Nil(t, ledger.AddTemplate(tt))
+
+	var input TransactionInput
+	assert.Nil(t, json.Unmarshal([]byte(payoutInputJson), &input))
+	assert.Equal(t, map[string]string{"total": "100.00"}, input.Parameters)
+	assert.Equal(t, 2, len(input.Lists["merchants"]))
+
+	transaction, err := ledger.CreateTransaction(input)
+	assert.Nil(t, err)
+	entries := transaction.Entries()
+	assert.Equal(t, 4, len(entries))
+	assert.Equal(t, "merchant[0]", entries[1].Key)
+	assert.Equal(t, "merchants/a", entries[1].Account.Key)
+	assert.Equal(t, "58.50 USD", entries[1].Amount.String())
+	assert.Equal(t, "merchant[1]", entries[2].Key)
+	assert.Equal(t, "40.00 USD", entries[2].Amount.String())
+	assert.Equal(t, "fee[0]", entries[3].Key)
+	assert.Equal(t, "1.50 USD", entries[3].Amount.String())
+
+	// The repeated amounts have to add up to the total.
+	input.Ledger.IK = "payout-2"
+	input.Parameters["total"] = "90.00"
+	_, err = ledger.CreateTransaction(input)
+	var unbalanced *UnbalancedError
+	assert.True(t, errors.As(err, &unbalanced))
+	assert.Equal(t, "90.00 USD", unbalanced.Debit.String())
+	assert.Equal(t, "100.00 USD", unbalanced.Credit.String())
+}
+
+func TestRepeatedLinesCheckRows(t *testing.T) {
+	ledger := newTestLedger(t, "clearing", "fees", "merchants/a")
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(payoutTemplateJson))
+	assert.Nil(t, err)
+	assert.Nil(t, ledger.AddTemplate(tt))
+
+	_, err = ledger.CreateTransaction(TransactionInput{
+		Type:       "payout",
+		Parameters: map[string]string{"total": "100.00", "merchants": "a"},
+		Lists: map[string][]map[string]string{
+			"merchants": {
+				{"account": "merchants/a", "amount": "sixty"},
+				{"account": "merchants/c", "amount": "40.00", "note": "x"},
+				{"amount": "1"},
+			},
+		},
+	})
+	var paramsErr *ParamsError
+	assert.True(t, errors.As(err, &paramsErr))
+	assert.Equal(t, []string{"merchants[2].account"}, paramsErr.Missing)
+	assert.Equal(t, []string{"merchants[1].note"}, paramsErr.Extra)
+	assert.Contains(t, paramsErr.Malformed, "merchants")
+	assert.Contains(t, paramsErr.Malformed, "merchants[0].amount")
+	assert.True(t, errors.Is(paramsErr.Malformed["merchants[1].account"], ErrAccountNotFound))
+
+	// Without a schema the list only has to be present.
+	tt.Parameters = nil
+	assert.Nil(t, ledger.AddTemplate(tt))
+	_, err = ledger.CreateTransaction(TransactionInput{Type: "payout", Parameters: map[string]string{"total": "1"}})
+	var lineErr *LineError
+	assert.True(t, errors.As(err, &lineErr))
+	assert.True(t, errors.Is(err, errMissingParameter))
+}
+
+func TestValidateRepeatedFields(t *testing.T) {
+	ledger := newTestLedger(t, "clearing", "fees")
+	tt, err := UnmarshalLedgerTransactionTemplate([]byte(payoutTemplateJson))
+	assert.Nil(t, err)
+	tt.LedgerEntriesTemplate[2].Amount = "{{.item.commission}}"
+
+	_, err = ValidateTemplate(tt, ledger.Store())
+	var templateErr *TemplateError
+	assert.True(t, errors.As(err, &templateErr))
+	assert.Equal(t, 1, len(templateErr.Problems))
+	assert.Contains(t, templateErr.Error(), "field commission of list merchants")
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"fmt"
+	"ledger/common"
+)
+
+// Reverse posts a transaction that undoes a posted transaction, with every
+// entry repeated on the other side of its account. Given entry ids, only those
+// lines are reversed, and they have to balance among themselves. An entry is
+// reversed at most once: reversing it again fails with ErrAlreadyReversed.
+//
+// The reversal points back at the original, through Transaction.Reverses and
+// Entries.Reverses, and is listed by Reversals. Reversed pending entries are
+// cancelled, releasing the funds they held, and reversing a cancellation holds
+// the funds again.
+func (l *Ledger) Reverse(transactionID string, entryIDs ...string) (*Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	original, err := l.store.Transaction(transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := original.entries
+	if len(entryIDs) > 0 {
+		selected = nil
+		for _, entryID := range entryIDs {
+			var found bool
+			for _, entry := range original.entries {
+				if entry.id == entryID {
+					selected = append(selected, entry)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("%w: %s is not part of transaction %s", ErrEntryNotFound, entryID, transactionID)
+			}
+		}
+	}
+
+	reversals, err := l.store.Reversals(transactionID)
+	if err != nil {
+		return nil, err
+	}
+	reversed := make(map[string]bool)
+	for _, reversal := range reversals {
+		for _, entry := range reversal.entries {
+			reversed[entry.reverses] = true
+		}
+	}
+
+	reversal := NewTransaction()
+	reversal.reverses = original.id
+	for _, entry := range selected {
+		if reversed[entry.id] {
+			return nil, fmt.Errorf("entry %s of transaction %s: %w", entry.id, transactionID, ErrAlreadyReversed)
+		}
+		reversed[entry.id] = true
+		flipped := NewEntry(entry.Account, entry.Amount, entry.Direction.Opposite(), entry.Status)
+		flipped.Key = entry.Key
+		flipped.reverses = entry.id
+		flipped.cancels = entry.Status == common.Pending && !entry.cancels
+		reversal.entries = append(reversal.entries, *flipped)
+	}
+	if err := l.post(reversal, newAccountCreator(l.store, false)); err != nil {
+		return nil, err
+	}
+	return reversal, nil
+}
+
+// Reversals returns the transactions that reverse a transaction, in the order
+// they were posted.
+func (l *Ledger) Reversals(transactionID string) ([]*Transaction, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, err := l.store.Transaction(transactionID); err != nil {
+		return nil, err
+	}
+	return l.store.Reversals(transactionID)
+}
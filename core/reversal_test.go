@@ -0,0 +1,201 @@
+package core
+
+import (
+	"errors"
+	"ledger/common"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	accounts := make(map[string]*Account)
+	for _, key := range []string{"bank", "income", "fees"} {
+		account, err := ledger.CreateAccount(&AccountTemplate{Key: key})
+		assert.Nil(t, err)
+		accounts[key] = account
+	}
+	original := NewTransaction(
+		*NewEntry(accounts["bank"], money(t, "100.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(accounts["income"], money(t, "100.00", "USD"), common.Credit, common.Posted),
+		*NewEntry(accounts["bank"], money(t, "5.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(accounts["fees"], money(t, "5.00", "USD"), common.Credit, common.Posted),
+	)
+	assert.Nil(t, ledger.Post(original))
+	ids := make([]string, len(original.entries))
+	for i, entry := range original.entries {
+		ids[i] = entry.ID()
+	}
+
+	// The selected lines have to balance.
+	_, err := ledger.Reverse(original.ID(), ids[2])
+	var unbalanced *UnbalancedError
+	assert.True(t, errors.As(err, &unbalanced))
+	_, err = ledger.Reverse(original.ID(), "missing")
+	assert.True(t, errors.Is(err, ErrEntryNotFound))
+
+	// Partial reversal of the fee.
+	partial, err := ledger.Reverse(original.ID(), ids[2], ids[3])
+	assert.Nil(t, err)
+	assert.Equal(t, original.ID(), partial.Reverses())
+	entries := partial.Entries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, common.Credit, entries[0].Direction)
+	assert.Equal(t, ids[2], entries[0].Reverses())
+	assert.Equal(t, common.Debit, entries[1].Direction)
+	balance, err := ledger.Balance("fees")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", balance.Posted.String())
+	balance, err = ledger.Balance("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "100.00 USD", balance.Posted.String())
+
+	// The fee lines can't be reversed twice, not even as part of the whole.
+	_, err = ledger.Reverse(original.ID())
+	assert.True(t, errors.Is(err, ErrAlreadyReversed))
+	_, err = ledger.Reverse(original.ID(), ids[3], ids[2])
+	assert.True(t, errors.Is(err, ErrAlreadyReversed))
+
+	rest, err := ledger.Reverse(original.ID(), ids[0], ids[1])
+	assert.Nil(t, err)
+	balance, err = ledger.Balance("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", balance.Posted.String())
+
+	// The relationship survives a reopen.
+	ledger = reopen(t, ledger, path)
+	reversals, err := ledger.Reversals(original.ID())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(reversals))
+	assert.Equal(t, partial.ID(), reversals[0].ID())
+	assert.Equal(t, rest.ID(), reversals[1].ID())
+	assert.Equal(t, ids[0], reversals[1].Entries()[0].Reverses())
+	_, err = ledger.Reverse(original.ID(), ids[0], ids[1])
+	assert.True(t, errors.Is(err, ErrAlreadyReversed))
+	balance, err = ledger.Balance("income")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", balance.Posted.String())
+
+	_, err = ledger.Reversals("missing")
+	assert.True(t, errors.Is(err, ErrTransactionNotFound))
+}
+
+func TestReversePartial(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income", "fees")
+	bank, _ := ledger.Account("bank")
+	income, _ := ledger.Account("income")
+	fees, _ := ledger.Account("fees")
+	original := NewTransaction(
+		*NewEntry(bank, money(t, "100.00", "USD"), common.Debit, common.Posted),
+		*NewEntry(income, money(t, "95.00", "USD"), common.Credit, common.Posted),
+		*NewEntry(fees, money(t, "5.00", "USD"), common.Credit, common.Posted),
+	)
+	assert.Nil(t, ledger.Post(original))
+
+	// Reversing only the fee leaves the lines unbalanced.
+	_, err := ledger.Reverse(original.ID(), original.entries[2].ID())
+	var unbalanced *UnbalancedError
+	assert.True(t, errors.As(err, &unbalanced))
+	reversals, err := ledger.Reversals(original.ID())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(reversals))
+
+	// Reversing the income line with part of bank is not possible either, as
+	// lines are reversed whole.
+	_, err = ledger.Reverse(original.ID(), original.entries[0].ID(), original.entries[1].ID())
+	assert.True(t, errors.As(err, &unbalanced))
+
+	reversal, err := ledger.Reverse(original.ID(), original.entries[0].ID(), original.entries[1].ID(), original.entries[2].ID())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(reversal.Entries()))
+	for _, key := range []string{"bank", "income", "fees"} {
+		balance, err := ledger.Balance(key)
+		assert.Nil(t, err)
+		assert.Equal(t, "0.00 USD", balance.Posted.String(), key)
+	}
+}
+
+func TestReverseTwice(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income")
+	original := postDeposit(t, ledger, "sale-1", "10.00")
+	reversal, err := ledger.Reverse("sale-1")
+	assert.Nil(t, err)
+	assert.Equal(t, original.ID(), reversal.Entries()[0].Reverses())
+
+	_, err = ledger.Reverse("sale-1")
+	assert.True(t, errors.Is(err, ErrAlreadyReversed))
+	_, err = ledger.Reverse("sale-1", original.ID())
+	assert.True(t, errors.Is(err, ErrAlreadyReversed))
+	balance, err := ledger.Balance("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", balance.Posted.String())
+
+	// A reversal is a transaction of its own, and can be reversed once too.
+	_, err = ledger.Reverse(reversal.ID())
+	assert.Nil(t, err)
+	_, err = ledger.Reverse(reversal.ID())
+	assert.True(t, errors.Is(err, ErrAlreadyReversed))
+	balance, err = ledger.Balance("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.00 USD", balance.Posted.String())
+}
+
+func TestReversePending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.journal")
+	ledger := openJournaledLedger(t, path)
+	for _, key := range []string{"bank", "income"} {
+		_, err := ledger.CreateAccount(&AccountTemplate{Key: key})
+		assert.Nil(t, err)
+	}
+	bank, _ := ledger.Account("bank")
+	income, _ := ledger.Account("income")
+	hold := NewTransaction(
+		*NewEntry(bank, money(t, "20.00", "USD"), common.Credit, common.Pending),
+		*NewEntry(income, money(t, "20.00", "USD"), common.Debit, common.Pending),
+	)
+	assert.Nil(t, ledger.Post(hold))
+	balance, err := ledger.Balance("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "-20.00 USD", balance.Available.String())
+
+	// The reversal stays pending, so it releases the hold without touching
+	// the posted balance.
+	reversal, err := ledger.Reverse(hold.ID())
+	assert.Nil(t, err)
+	for _, entry := range reversal.Entries() {
+		assert.Equal(t, common.Pending, entry.Status)
+	}
+	for _, reopened := range []bool{false, true} {
+		if reopened {
+			ledger = reopen(t, ledger, path)
+		}
+		balance, err = ledger.Balance("bank")
+		assert.Nil(t, err)
+		assert.Equal(t, "0.00 USD", balance.Posted.String())
+		assert.Equal(t, "0.00 USD", balance.Pending.String())
+		assert.Equal(t, "0.00 USD", balance.Available.String())
+	}
+
+	// Reversing the cancellation holds the funds again, and reversing that
+	// releases them once more.
+	rehold, err := ledger.Reverse(reversal.ID())
+	assert.Nil(t, err)
+	for _, entry := range rehold.Entries() {
+		assert.False(t, entry.Cancels())
+	}
+	ledger = reopen(t, ledger, path)
+	balance, err = ledger.Balance("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", balance.Posted.String())
+	assert.Equal(t, "-20.00 USD", balance.Pending.String())
+	assert.Equal(t, "-20.00 USD", balance.Available.String())
+	_, err = ledger.Reverse(rehold.ID())
+	assert.Nil(t, err)
+	balance, err = ledger.Balance("bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00 USD", balance.Pending.String())
+	assert.Equal(t, "0.00 USD", balance.Available.String())
+}
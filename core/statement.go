@@ -0,0 +1,304 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"ledger/common"
+	"strings"
+	"time"
+)
+
+// Statements are written from the point of view of the account holder at the
+// bank: money arriving is a credit on the statement and a Debit of the bank
+// account in the ledger. The parsers below turn them into external records on
+// the ledger side.
+
+// CSVMapping names the columns of a CSV statement, as they appear in its
+// header row.
+type CSVMapping struct {
+	ID     string `json:"id,omitempty"` // records without an id get one when added
+	Amount string `json:"amount,omitempty"`
+	// In and Out name separate columns for money arriving and leaving, for
+	// statements without a signed Amount column.
+	In  string `json:"in,omitempty"`
+	Out string `json:"out,omitempty"`
+	// Direction names a column with credit or debit indicators such as CRDT,
+	// CR or C. Without it the sign of Amount tells the direction.
+	Direction       string `json:"direction,omitempty"`
+	Currency        string `json:"currency,omitempty"`
+	DefaultCurrency string `json:"default_currency,omitempty"` // when there is no Currency column
+	ValueDate       string `json:"value_date"`
+	DateFormat      string `json:"date_format,omitempty"` // Go layout, 2006-01-02 by default
+	Reference       string `json:"reference,omitempty"`
+	Counterparty    string `json:"counterparty,omitempty"`
+	Separator       string `json:"separator,omitempty"`     // "," by default
+	DecimalComma    bool   `json:"decimal_comma,omitempty"` // amounts written as 1.234,56
+	Source          string `json:"source,omitempty"`
+}
+
+// ParseCSVStatement reads the lines of a CSV statement of account as external
+// records.
+func ParseCSVStatement(r io.Reader, account string, mapping CSVMapping) ([]*ExternalRecord, error) {
+	if mapping.Amount == "" && mapping.In == "" && mapping.Out == "" {
+		return nil, errors.New("csv statement: no amount column")
+	}
+	if mapping.ValueDate == "" {
+		return nil, errors.New("csv statement: no value date column")
+	}
+	if mapping.Currency == "" && mapping.DefaultCurrency == "" {
+		return nil, errors.New("csv statement: no currency column or default currency")
+	}
+	layout := mapping.DateFormat
+	if layout == "" {
+		layout = time.DateOnly
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if mapping.Separator != "" {
+		reader.Comma = []rune(mapping.Separator)[0]
+	}
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv statement: header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{mapping.ID, mapping.Amount, mapping.In, mapping.Out, mapping.Direction,
+		mapping.Currency, mapping.ValueDate, mapping.Reference, mapping.Counterparty} {
+		if _, exists := columns[strings.ToLower(name)]; name != "" && !exists {
+			return nil, fmt.Errorf("csv statement: no column %q", name)
+		}
+	}
+
+	var records []*ExternalRecord
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv statement: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, exists := columns[strings.ToLower(name)]; name != "" && exists && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(fields, "")) == "" {
+			continue
+		}
+
+		code := field(mapping.Currency)
+		if code == "" {
+			code = mapping.DefaultCurrency
+		}
+		amount, direction, err := csvAmount(mapping, field, code)
+		if err != nil {
+			return nil, fmt.Errorf("csv statement: line %d: %w", line, err)
+		}
+		valueDate, err := time.Parse(layout, field(mapping.ValueDate))
+		if err != nil {
+			return nil, fmt.Errorf("csv statement: line %d: value date: %w", line, err)
+		}
+		records = append(records, &ExternalRecord{
+			ID:           field(mapping.ID),
+			Account:      account,
+			Amount:       amount,
+			Direction:    direction,
+			ValueDate:    valueDate,
+			Reference:    field(mapping.Reference),
+			Counterparty: field(mapping.Counterparty),
+			Source:       mapping.Source,
+		})
+	}
+	return records, nil
+}
+
+// csvAmount reads the amount of a CSV line and the side of the account it
+// belongs on.
+func csvAmount(mapping CSVMapping, field func(string) string, code string) (common.Money, common.Direction, error) {
+	parse := func(text string) (common.Money, error) {
+		text = strings.ReplaceAll(text, " ", "")
+		if mapping.DecimalComma {
+			text = strings.ReplaceAll(strings.ReplaceAll(text, ".", ""), ",", ".")
+		} else {
+			text = strings.ReplaceAll(text, ",", "")
+		}
+		return common.ParseMoney(text, code)
+	}
+
+	if mapping.Amount == "" || field(mapping.Amount) == "" {
+		in, out := field(mapping.In), field(mapping.Out)
+		switch {
+		case in != "" && out == "":
+			amount, err := parse(in)
+			return amount, common.Debit, err
+		case out != "" && in == "":
+			amount, err := parse(out)
+			return amount, common.Credit, err
+		}
+		return common.Money{}, 0, errors.New("needs an amount")
+	}
+
+	amount, err := parse(field(mapping.Amount))
+	if err != nil {
+		return common.Money{}, 0, err
+	}
+	direction := common.Debit
+	if amount.Sign() < 0 {
+		amount, direction = amount.Neg(), common.Credit
+	}
+	if mapping.Direction != "" {
+		indicator := field(mapping.Direction)
+		var known bool
+		if direction, known = bankDirection(indicator); !known {
+			return common.Money{}, 0, fmt.Errorf("unknown credit or debit indicator %q", indicator)
+		}
+	}
+	return amount, direction, nil
+}
+
+// bankDirection turns a credit or debit indicator of a statement into the
+// side of the bank account the amount belongs on.
+func bankDirection(indicator string) (common.Direction, bool) {
+	switch strings.ToUpper(strings.TrimSpace(indicator)) {
+	case "CRDT", "CR", "C", "CREDIT":
+		return common.Debit, true
+	case "DBIT", "DR", "D", "DEBIT":
+		return common.Credit, true
+	}
+	return 0, false
+}
+
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	ID      string      `xml:"Id"`
+	Entries []camtEntry `xml:"Ntry"`
+}
+
+type camtEntry struct {
+	Reference string `xml:"NtryRef"`
+	Amount    struct {
+		Value    string `xml:",chardata"`
+		Currency string `xml:"Ccy,attr"`
+	} `xml:"Amt"`
+	Indicator string `xml:"CdtDbtInd"`
+	Status    struct {
+		Value string `xml:",chardata"`
+		Code  string `xml:"Cd"` // camt.053.001.08 and later
+	} `xml:"Sts"`
+	BookingDate camtDate          `xml:"BookgDt"`
+	ValueDate   camtDate          `xml:"ValDt"`
+	ServicerRef string            `xml:"AcctSvcrRef"`
+	Details     []camtTransaction `xml:"NtryDtls>TxDtls"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (d camtDate) time() (time.Time, error) {
+	if d.Date != "" {
+		return time.Parse(time.DateOnly, strings.TrimSpace(d.Date))
+	}
+	text := strings.TrimSpace(d.DateTime)
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05", text)
+}
+
+type camtTransaction struct {
+	EndToEndID    string   `xml:"Refs>EndToEndId"`
+	Debtor        string   `xml:"RltdPties>Dbtr>Nm"`
+	DebtorParty   string   `xml:"RltdPties>Dbtr>Pty>Nm"`
+	Creditor      string   `xml:"RltdPties>Cdtr>Nm"`
+	CreditorParty string   `xml:"RltdPties>Cdtr>Pty>Nm"`
+	Remittance    []string `xml:"RmtInf>Ustrd"`
+}
+
+// ParseCamt053 reads the booked entries of an ISO 20022 camt.053 statement of
+// account as external records. Pending entries are left out, as the bank may
+// still change them.
+//
+// The reference of a record is the end-to-end id of the payment, or else its
+// unstructured remittance information, and its counterparty is the debtor of
+// money arriving or the creditor of money leaving. Entries that batch several
+// payments keep the reference of the entry itself.
+func ParseCamt053(r io.Reader, account string) ([]*ExternalRecord, error) {
+	var document camtDocument
+	if err := xml.NewDecoder(r).Decode(&document); err != nil {
+		return nil, fmt.Errorf("camt.053: %w", err)
+	}
+	var records []*ExternalRecord
+	for _, statement := range document.Statements {
+		for i, entry := range statement.Entries {
+			status := strings.TrimSpace(entry.Status.Value + entry.Status.Code)
+			if status != "" && status != "BOOK" {
+				continue
+			}
+			id := entry.ServicerRef
+			if id == "" {
+				reference := entry.Reference
+				if reference == "" {
+					reference = fmt.Sprint(i + 1)
+				}
+				id = statement.ID + "/" + reference
+			}
+
+			direction, known := bankDirection(entry.Indicator)
+			if !known {
+				return nil, fmt.Errorf("camt.053: entry %s: unknown credit or debit indicator %q", id, entry.Indicator)
+			}
+			amount, err := common.ParseMoney(strings.TrimSpace(entry.Amount.Value), entry.Amount.Currency)
+			if err != nil {
+				return nil, fmt.Errorf("camt.053: entry %s: %w", id, err)
+			}
+			date := entry.ValueDate
+			if date.Date == "" && date.DateTime == "" {
+				date = entry.BookingDate
+			}
+			valueDate, err := date.time()
+			if err != nil {
+				return nil, fmt.Errorf("camt.053: entry %s: value date: %w", id, err)
+			}
+
+			record := &ExternalRecord{
+				ID:        id,
+				Account:   account,
+				Amount:    amount,
+				Direction: direction,
+				ValueDate: valueDate,
+				Reference: entry.Reference,
+				Source:    statement.ID,
+			}
+			if len(entry.Details) == 1 {
+				details := entry.Details[0]
+				switch {
+				case details.EndToEndID != "" && details.EndToEndID != "NOTPROVIDED":
+					record.Reference = details.EndToEndID
+				case len(details.Remittance) > 0:
+					record.Reference = strings.Join(details.Remittance, " ")
+				}
+				record.Counterparty = details.Debtor + details.DebtorParty
+				if direction == common.Credit {
+					record.Counterparty = details.Creditor + details.CreditorParty
+				}
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
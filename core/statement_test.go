@@ -0,0 +1,150 @@
+package core
+
+import (
+	"ledger/common"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const csvStatement = `Date;Description;Reference;Amount;Currency
+01.03.2024;ACME Corp;sale-1;1.250,00;USD
+02.03.2024;Bank fees;;-12,50;USD
+
+04.03.2024;Jane Doe;sale-2;80,00;EUR
+`
+
+const camt053Statement = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Id>STMT-2024-03</Id>
+      <Ntry>
+        <NtryRef>1</NtryRef>
+        <Amt Ccy="USD">100.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2024-03-01</Dt></BookgDt>
+        <ValDt><Dt>2024-03-01</Dt></ValDt>
+        <AcctSvcrRef>BANK-0001</AcctSvcrRef>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>sale-1</EndToEndId></Refs>
+          <RltdPties><Dbtr><Nm>ACME Corp</Nm></Dbtr></RltdPties>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <NtryRef>2</NtryRef>
+        <Amt Ccy="USD">42.10</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2024-03-02</Dt></BookgDt>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>NOTPROVIDED</EndToEndId></Refs>
+          <RltdPties><Cdtr><Nm>Office Supplies Ltd</Nm></Cdtr></RltdPties>
+          <RmtInf><Ustrd>Invoice 7781</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <NtryRef>3</NtryRef>
+        <Amt Ccy="USD">5.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>PDNG</Sts>
+        <BookgDt><Dt>2024-03-03</Dt></BookgDt>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+func TestParseCSVStatement(t *testing.T) {
+	mapping := CSVMapping{
+		Amount:       "amount",
+		Currency:     "Currency",
+		ValueDate:    "Date",
+		DateFormat:   "02.01.2006",
+		Reference:    "Reference",
+		Counterparty: "Description",
+		Separator:    ";",
+		DecimalComma: true,
+		Source:       "statement-2024-03",
+	}
+	records, err := ParseCSVStatement(strings.NewReader(csvStatement), "bank", mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(records))
+
+	assert.Equal(t, &ExternalRecord{
+		Account:      "bank",
+		Amount:       money(t, "1250.00", "USD"),
+		Direction:    common.Debit,
+		ValueDate:    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Reference:    "sale-1",
+		Counterparty: "ACME Corp",
+		Source:       "statement-2024-03",
+	}, records[0])
+	assert.Equal(t, "12.50 USD", records[1].Amount.String())
+	assert.Equal(t, common.Credit, records[1].Direction)
+	assert.Equal(t, "80.00 EUR", records[2].Amount.String())
+
+	// Separate columns for money in and out, with indicators.
+	inOut := "id,date,in,out\nl1,2024-03-01,\"1,000.00\",\nl2,2024-03-02,,20.00\n"
+	records, err = ParseCSVStatement(strings.NewReader(inOut), "bank", CSVMapping{ID: "id", In: "in", Out: "out", ValueDate: "date", DefaultCurrency: "USD"})
+	assert.Nil(t, err)
+	assert.Equal(t, "l1", records[0].ID)
+	assert.Equal(t, "1000.00 USD", records[0].Amount.String())
+	assert.Equal(t, common.Debit, records[0].Direction)
+	assert.Equal(t, common.Credit, records[1].Direction)
+
+	withIndicator := "date,amount,cd\n2024-03-01,10.00,DBIT\n"
+	records, err = ParseCSVStatement(strings.NewReader(withIndicator), "bank", CSVMapping{Amount: "amount", Direction: "cd", ValueDate: "date", DefaultCurrency: "USD"})
+	assert.Nil(t, err)
+	assert.Equal(t, common.Credit, records[0].Direction)
+
+	_, err = ParseCSVStatement(strings.NewReader(withIndicator), "bank", CSVMapping{Amount: "total", ValueDate: "date", DefaultCurrency: "USD"})
+	assert.NotNil(t, err)
+	_, err = ParseCSVStatement(strings.NewReader("date,amount\n2024-03-01,ten\n"), "bank", CSVMapping{Amount: "amount", ValueDate: "date", DefaultCurrency: "USD"})
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestParseCamt053(t *testing.T) {
+	records, err := ParseCamt053(strings.NewReader(camt053Statement), "bank")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+
+	assert.Equal(t, &ExternalRecord{
+		ID:           "BANK-0001",
+		Account:      "bank",
+		Amount:       money(t, "100.00", "USD"),
+		Direction:    common.Debit,
+		ValueDate:    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Reference:    "sale-1",
+		Counterparty: "ACME Corp",
+		Source:       "STMT-2024-03",
+	}, records[0])
+
+	// Without a value date the booking date is used.
+	assert.Equal(t, "STMT-2024-03/2", records[1].ID)
+	assert.Equal(t, common.Credit, records[1].Direction)
+	assert.Equal(t, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), records[1].ValueDate)
+	assert.Equal(t, "Invoice 7781", records[1].Reference)
+	assert.Equal(t, "Office Supplies Ltd", records[1].Counterparty)
+
+	// The records are ready to be matched.
+	ledger, err := NewLedger(NewMemoryStore())
+	assert.Nil(t, err)
+	for _, key := range []string{"bank", "income"} {
+		_, err := ledger.CreateAccount(&AccountTemplate{Key: key})
+		assert.Nil(t, err)
+	}
+	sale := postDeposit(t, ledger, "sale-1", "100.00")
+	for _, record := range records {
+		assert.Nil(t, ledger.AddExternalRecord(record))
+	}
+	result, err := ledger.Match("bank", MatchRule{Name: "reference", Reference: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Proposals))
+	assert.Equal(t, []string{sale.ID()}, result.Proposals[0].Entries)
+
+	_, err = ParseCamt053(strings.NewReader("<Document><BkToCstmrStmt>"), "bank")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,67 @@
+package core
+
+import "errors"
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrTransactionExists   = errors.New("transaction already exists")
+	ErrEntryNotFound       = errors.New("entry not found")
+	ErrTemplateNotFound    = errors.New("template not found")
+	ErrTemplateExists      = errors.New("template version already exists")
+	ErrTemplateInUse       = errors.New("template version in use")
+	ErrRecordNotFound      = errors.New("external record not found")
+	ErrRecordExists        = errors.New("external record already exists")
+	ErrPeriodNotFound      = errors.New("period not found")
+)
+
+// Store persists the accounts, transactions, entries and templates of a
+// ledger. Lookups of missing items return an error wrapping the matching
+// ErrXxxNotFound value.
+type Store interface {
+	Account(key string) (*Account, error)
+	Accounts() ([]*Account, error)
+	PutAccount(account *Account) error
+
+	// Account templates describe accounts that are created on demand. They
+	// are returned in the order they were put.
+	AccountTemplates() ([]*AccountTemplate, error)
+	PutAccountTemplate(accountType *AccountTemplate) error
+
+	// Transactions are returned in the order they were put. Reversals
+	// returns the transactions that reverse the one with the given id.
+	Transaction(id string) (*Transaction, error)
+	Transactions() ([]*Transaction, error)
+	Reversals(transactionID string) ([]*Transaction, error)
+	PutTransaction(transaction *Transaction) error
+
+	Entry(id string) (*Entries, error)
+	AccountEntries(accountKey string) ([]Entries, error)
+
+	// Templates are kept per type and version. Template returns the latest
+	// version of a type when version is 0, and Templates the latest version
+	// of every type. Version numbers are never handed out twice:
+	// LastTemplateVersion returns the highest version ever put for a type,
+	// deleted or not, and PutTemplate rejects versions up to it.
+	Template(transactionType string, version int) (*TransactionTemplate, error)
+	Templates() ([]*TransactionTemplate, error)
+	TemplateVersions(transactionType string) ([]*TransactionTemplate, error)
+	LastTemplateVersion(transactionType string) (int, error)
+	PutTemplate(template *TransactionTemplate) error
+	DeleteTemplate(transactionType string, version int) error
+
+	// External records are returned in the order they were put, and links
+	// in the order they were made.
+	ExternalRecord(id string) (*ExternalRecord, error)
+	ExternalRecords(accountKey string) ([]*ExternalRecord, error)
+	PutExternalRecord(record *ExternalRecord) error
+	RecordLinks(recordID string) ([]ReconLink, error)
+	EntryLinks(entryID string) ([]ReconLink, error)
+	PutLink(link ReconLink) error
+	DeleteRecordLinks(recordID string) error
+
+	// Periods are returned in order of their start. PutPeriod adds a period
+	// or replaces the one with the same name.
+	Period(name string) (*Period, error)
+	Periods() ([]*Period, error)
+	PutPeriod(period *Period) error
+}
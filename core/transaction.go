@@ -1,31 +1,50 @@
 package core
 
 import (
+	"errors"
 	"ledger/common"
-	"math/big"
+	"time"
 
 	"github.com/rs/xid"
 )
 
 type Transaction struct {
-	id      string
-	entries []Entries
+	id          string
+	entries     []Entries
+	inputHash   common.Hash // hash of the TransactionInput the transaction was created from
+	template    TemplateRef // template version the transaction was created from, if any
+	postedAt    time.Time
+	effectiveAt time.Time // value date the entries count from, postedAt unless set
+	reverses    string    // id of the transaction t reverses, if any
+	adjusts     string    // name of the closed period t corrects, if any
+}
+
+// TemplateRef names a version of a transaction template.
+type TemplateRef struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
 }
 
 type Entries struct {
-	id        string
-	Account   *Account
-	Amount    *big.Int
-	Direction common.Direction
+	id          string
+	transaction string // id of the transaction the entry was posted with
+	reverses    string // id of the entry this one reverses, if any
+	cancels     bool   // whether the entry releases the hold of the pending entry it reverses
+	Key         string // key of the template line that produced the entry
+	Account     *Account
+	Amount      common.Money
+	Direction   common.Direction
+	Status      common.Status
 }
 
 // newEntries creates a new Entries with a unique id.
-func NewEntry(Account *Account, amount *big.Int, direction common.Direction, status common.Status) *Entries {
+func NewEntry(Account *Account, amount common.Money, direction common.Direction, status common.Status) *Entries {
 	return &Entries{
 		id:        xid.New().String(),
 		Account:   Account,
 		Amount:    amount,
 		Direction: direction,
+		Status:    status,
 	}
 }
 
@@ -37,6 +56,106 @@ func NewTransaction(entries ...Entries) *Transaction {
 	}
 }
 
+func (t *Transaction) ID() string {
+	return t.id
+}
+
+// Template returns the template version t was created from. It is zero for
+// transactions that were not created from a registered template.
+func (t *Transaction) Template() TemplateRef {
+	return t.template
+}
+
+// PostedAt returns when t was posted to the ledger.
+func (t *Transaction) PostedAt() time.Time {
+	return t.postedAt
+}
+
+// EffectiveAt returns the value date of t, from which its entries count in
+// balances as of a date.
+func (t *Transaction) EffectiveAt() time.Time {
+	return t.effectiveAt
+}
+
+// SetEffectiveAt sets the value date of t before it is posted. Transactions
+// without one are effective when they are posted. A date before the posting
+// backdates the transaction.
+func (t *Transaction) SetEffectiveAt(at time.Time) {
+	t.effectiveAt = at
+}
+
+// Reverses returns the id of the transaction t reverses, or "" when t is not
+// a reversal.
+func (t *Transaction) Reverses() string {
+	return t.reverses
+}
+
+// Adjusts returns the name of the closed period t is an adjusting
+// transaction for, or "" when it is not one.
+func (t *Transaction) Adjusts() string {
+	return t.adjusts
+}
+
+// Entries returns a copy of the entries of t.
+func (t *Transaction) Entries() []Entries {
+	return append([]Entries(nil), t.entries...)
+}
+
+func (e *Entries) ID() string {
+	return e.id
+}
+
+// TransactionID returns the id of the transaction e was posted with.
+func (e *Entries) TransactionID() string {
+	return e.transaction
+}
+
+// Reverses returns the id of the entry e reverses, or "" when e is not part
+// of a reversal.
+func (e *Entries) Reverses() string {
+	return e.reverses
+}
+
+// Cancels reports whether e releases the hold of the pending entry it
+// reverses.
+func (e *Entries) Cancels() bool {
+	return e.cancels
+}
+
+// Balanced reports an *UnbalancedError for every currency in which the
+// debits of t do not equal its credits.
+func (t *Transaction) Balanced() error {
+	totals := make(map[common.Currency]*UnbalancedError)
+	var currencies []common.Currency
+	for _, entry := range t.entries {
+		total, exists := totals[entry.Amount.Currency]
+		if !exists {
+			total = &UnbalancedError{
+				Debit:  common.Zero(entry.Amount.Currency),
+				Credit: common.Zero(entry.Amount.Currency),
+			}
+			totals[entry.Amount.Currency] = total
+			currencies = append(currencies, entry.Amount.Currency)
+		}
+		// Both are in the currency of the entry, so adding can't fail.
+		switch entry.Direction {
+		case common.Debit:
+			total.Debit, _ = total.Debit.Add(entry.Amount)
+		case common.Credit:
+			total.Credit, _ = total.Credit.Add(entry.Amount)
+		}
+		total.Lines = append(total.Lines, entry)
+	}
+
+	var errs []error
+	for _, currency := range currencies {
+		if total := totals[currency]; total.Debit.Cmp(total.Credit) != 0 {
+			errs = append(errs, total)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 //convert transactions into double ledger Transaction
 //
 
@@ -1,8 +1,8 @@
 package core
 
 import (
+	"errors"
 	"ledger/common"
-	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,7 +13,7 @@ func TestNewAccount(t *testing.T) {
 		Key:  "test-key",
 		Name: "Test Account",
 	}
-	amount := big.NewInt(1000)
+	amount := money(t, "10.00", "USD")
 
 	entry := NewEntry(account, amount, common.Debit, common.Posted)
 	assert.NotNil(t, entry)
@@ -26,14 +26,14 @@ func TestNewTransaction(t *testing.T) {
 	entry1 := Entries{
 		id:        "test-id-1",
 		Account:   &Account{},
-		Amount:    big.NewInt(1000),
+		Amount:    money(t, "1000", "JPY"),
 		Direction: common.Debit,
 	}
 
 	entry2 := Entries{
 		id:        "test-id-2",
 		Account:   &Account{},
-		Amount:    big.NewInt(500),
+		Amount:    money(t, "500", "JPY"),
 		Direction: common.Credit,
 	}
 
@@ -42,4 +42,21 @@ func TestNewTransaction(t *testing.T) {
 	assert.Equal(t, 2, len(transaction.entries))
 }
 
+func TestBalancedWithZeroValueAmount(t *testing.T) {
+	usd, err := common.LookupCurrency("USD")
+	assert.Nil(t, err)
+	transaction := NewTransaction(
+		Entries{Account: &Account{}, Amount: common.Money{Currency: usd}, Direction: common.Debit},
+		Entries{Account: &Account{}, Amount: money(t, "0.00", "USD"), Direction: common.Credit},
+	)
+	assert.Nil(t, transaction.Balanced())
+
+	transaction = NewTransaction(
+		Entries{Account: &Account{}, Amount: common.Money{Currency: usd}, Direction: common.Debit},
+		Entries{Account: &Account{}, Amount: money(t, "1.00", "USD"), Direction: common.Credit},
+	)
+	var unbalanced *UnbalancedError
+	assert.True(t, errors.As(transaction.Balanced(), &unbalanced))
+}
+
 // ... add more tests as needed.
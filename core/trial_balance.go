@@ -0,0 +1,157 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"ledger/common"
+	"sort"
+	"time"
+)
+
+// TrialBalance lists the posted debits and credits of every account over a
+// range of effective times, from From up to but not including To.
+type TrialBalance struct {
+	From   time.Time           `json:"from"`
+	To     time.Time           `json:"to"`
+	Lines  []TrialBalanceLine  `json:"lines"`
+	Totals []TrialBalanceTotal `json:"totals"`
+}
+
+// TrialBalanceLine is an account in one currency. Opening and Closing are
+// signed by the normal side of the account type, like Balance.
+type TrialBalanceLine struct {
+	Account  string             `json:"account"`
+	Name     string             `json:"name,omitempty"`
+	Type     common.AccountType `json:"type"`
+	Currency string             `json:"currency"`
+	Opening  common.Money       `json:"opening"`
+	Debit    common.Money       `json:"debit"`
+	Credit   common.Money       `json:"credit"`
+	Closing  common.Money       `json:"closing"`
+}
+
+// TrialBalanceTotal sums the debits and credits of the lines in a currency.
+type TrialBalanceTotal struct {
+	Currency string       `json:"currency"`
+	Debit    common.Money `json:"debit"`
+	Credit   common.Money `json:"credit"`
+}
+
+// Balanced reports whether the debits equal the credits in every currency.
+func (tb *TrialBalance) Balanced() bool {
+	for _, total := range tb.Totals {
+		if total.Debit.Cmp(total.Credit) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TrialBalance reports the posted entries effective from from up to but not
+// including to, per account and currency in order of account key. A zero from
+// starts at the first entry. Accounts without entries effective before to are
+// listed with zero amounts, in the currency of the account when it has one.
+func (l *Ledger) TrialBalance(from time.Time, to time.Time) (*TrialBalance, error) {
+	if !from.IsZero() && !from.Before(to) {
+		return nil, fmt.Errorf("trial balance: from %s is not before to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	accounts, err := l.store.Accounts()
+	if err != nil {
+		return nil, err
+	}
+
+	// Empty reports list their lines and totals as empty arrays in JSON.
+	report := &TrialBalance{From: from, To: to, Lines: []TrialBalanceLine{}, Totals: []TrialBalanceTotal{}}
+	totals := make(map[common.Currency]*TrialBalanceTotal)
+	for _, account := range accounts {
+		normal := account.Type.NormalBalance()
+		var lines []TrialBalanceLine
+		for currency, history := range l.history[account.Key] {
+			closing, exists := history.asOf(to.Add(-time.Nanosecond))
+			if !exists {
+				continue
+			}
+			opening := newAccountBalance(currency)
+			if !from.IsZero() {
+				if balance, exists := history.asOf(from.Add(-time.Nanosecond)); exists {
+					opening = balance
+				}
+			}
+			activity := closing.since(opening)
+			line := TrialBalanceLine{
+				Account:  account.Key,
+				Name:     account.Name,
+				Type:     account.Type,
+				Currency: currency.Code,
+				Opening:  opening.balance(normal).Posted,
+				Debit:    common.NewMoney(activity.postedDebit, currency),
+				Credit:   common.NewMoney(activity.postedCredit, currency),
+				Closing:  closing.balance(normal).Posted,
+			}
+			lines = append(lines, line)
+
+			total, exists := totals[currency]
+			if !exists {
+				total = &TrialBalanceTotal{Currency: currency.Code, Debit: common.Zero(currency), Credit: common.Zero(currency)}
+				totals[currency] = total
+			}
+			total.Debit, _ = total.Debit.Add(line.Debit)
+			total.Credit, _ = total.Credit.Add(line.Credit)
+		}
+		if len(lines) == 0 {
+			code := account.Currency
+			if code == "" {
+				code = common.NoCurrency
+			}
+			currency, err := common.LookupCurrency(code)
+			if err != nil {
+				return nil, err
+			}
+			zero := common.Zero(currency)
+			lines = append(lines, TrialBalanceLine{
+				Account:  account.Key,
+				Name:     account.Name,
+				Type:     account.Type,
+				Currency: currency.Code,
+				Opening:  zero,
+				Debit:    zero,
+				Credit:   zero,
+				Closing:  zero,
+			})
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i].Currency < lines[j].Currency })
+		report.Lines = append(report.Lines, lines...)
+	}
+	for _, total := range totals {
+		report.Totals = append(report.Totals, *total)
+	}
+	sort.Slice(report.Totals, func(i, j int) bool { return report.Totals[i].Currency < report.Totals[j].Currency })
+	return report, nil
+}
+
+// WriteCSV writes tb as CSV with a header row, one row per line and a total
+// row per currency. Amounts are plain decimals in the currency of the row.
+func (tb *TrialBalance) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"account", "name", "type", "currency", "opening", "debit", "credit", "closing"})
+	for _, line := range tb.Lines {
+		writer.Write([]string{line.Account, line.Name, line.Type.String(), line.Currency,
+			line.Opening.Decimal().String(), line.Debit.Decimal().String(), line.Credit.Decimal().String(), line.Closing.Decimal().String()})
+	}
+	for _, total := range tb.Totals {
+		writer.Write([]string{"total", "", "", total.Currency, "", total.Debit.Decimal().String(), total.Credit.Decimal().String(), ""})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON writes tb as indented JSON.
+func (tb *TrialBalance) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(tb)
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"ledger/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrialBalance(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, accountType := range []*AccountTemplate{
+		{Key: "cash", Name: "Cash", Type: common.Asset},
+		{Key: "expenses", Name: "Expenses", Type: common.Expense},
+		{Key: "revenue", Name: "Revenue", Type: common.Income},
+	} {
+		_, err := ledger.CreateAccount(accountType)
+		assert.Nil(t, err)
+	}
+	postAt(t, ledger, date(time.February, 20), "cash", "revenue", "USD", "100.00")
+	postAt(t, ledger, date(time.March, 5), "cash", "revenue", "USD", "50.00")
+	postAt(t, ledger, date(time.March, 6), "cash", "revenue", "EUR", "10.00")
+	postAt(t, ledger, date(time.March, 10), "expenses", "cash", "USD", "30.00")
+	postAt(t, ledger, date(time.April, 1), "cash", "revenue", "USD", "5.00")
+
+	report, err := ledger.TrialBalance(date(time.March, 1), date(time.April, 1))
+	assert.Nil(t, err)
+	assert.True(t, report.Balanced())
+	assert.Equal(t, 5, len(report.Lines))
+	assert.Equal(t, TrialBalanceLine{
+		Account:  "cash",
+		Name:     "Cash",
+		Type:     common.Asset,
+		Currency: "USD",
+		Opening:  money(t, "100.00", "USD"),
+		Debit:    money(t, "50.00", "USD"),
+		Credit:   money(t, "30.00", "USD"),
+		Closing:  money(t, "120.00", "USD"),
+	}, report.Lines[1])
+	assert.Equal(t, []TrialBalanceTotal{
+		{Currency: "EUR", Debit: money(t, "10.00", "EUR"), Credit: money(t, "10.00", "EUR")},
+		{Currency: "USD", Debit: money(t, "80.00", "USD"), Credit: money(t, "80.00", "USD")},
+	}, report.Totals)
+
+	var out bytes.Buffer
+	assert.Nil(t, report.WriteCSV(&out))
+	assert.Equal(t, `account,name,type,currency,opening,debit,credit,closing
+cash,Cash,asset,EUR,0.00,10.00,0.00,10.00
+cash,Cash,asset,USD,100.00,50.00,30.00,120.00
+expenses,Expenses,expense,USD,0.00,30.00,0.00,30.00
+revenue,Revenue,income,EUR,0.00,0.00,10.00,10.00
+revenue,Revenue,income,USD,100.00,0.00,50.00,150.00
+total,,,EUR,,10.00,10.00,
+total,,,USD,,80.00,80.00,
+`, out.String())
+
+	out.Reset()
+	assert.Nil(t, report.WriteJSON(&out))
+	var decoded TrialBalance
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, report.Lines, decoded.Lines)
+	assert.Equal(t, report.Totals, decoded.Totals)
+
+	// From the beginning, everything up to the end of April.
+	report, err = ledger.TrialBalance(time.Time{}, date(time.May, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, "155.00 USD", report.Lines[4].Credit.String())
+	assert.Equal(t, "0.00 USD", report.Lines[4].Opening.String())
+
+	_, err = ledger.TrialBalance(date(time.April, 1), date(time.March, 1))
+	assert.NotNil(t, err)
+}
+
+// errWriter fails every write.
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestTrialBalanceCSV(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, accountType := range []*AccountTemplate{
+		{Key: "cash", Name: `Cash, "petty"`, Type: common.Asset},
+		{Key: "revenue", Type: common.Income},
+	} {
+		_, err := ledger.CreateAccount(accountType)
+		assert.Nil(t, err)
+	}
+	postAt(t, ledger, time.Time{}, "cash", "revenue", "USD", "1234.5")
+	report, err := ledger.TrialBalance(time.Time{}, ledger.now().Add(time.Hour))
+	assert.Nil(t, err)
+
+	// Fields are quoted as needed and amounts are plain decimals.
+	var out bytes.Buffer
+	assert.Nil(t, report.WriteCSV(&out))
+	assert.Equal(t, `account,name,type,currency,opening,debit,credit,closing
+cash,"Cash, ""petty""",asset,USD,0.00,1234.50,0.00,1234.50
+revenue,,income,USD,0.00,0.00,1234.50,1234.50
+total,,,USD,,1234.50,1234.50,
+`, out.String())
+	rows, err := csv.NewReader(&out).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, `Cash, "petty"`, rows[1][1])
+
+	empty, err := newTestLedger(t).TrialBalance(time.Time{}, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	out.Reset()
+	assert.Nil(t, empty.WriteCSV(&out))
+	assert.Equal(t, "account,name,type,currency,opening,debit,credit,closing\n", out.String())
+
+	assert.NotNil(t, report.WriteCSV(errWriter{}))
+}
+
+func TestTrialBalanceJSON(t *testing.T) {
+	ledger := newTestLedger(t)
+	for _, accountType := range []*AccountTemplate{
+		{Key: "cash", Name: "Cash", Type: common.Asset},
+		{Key: "revenue", Type: common.Income},
+	} {
+		_, err := ledger.CreateAccount(accountType)
+		assert.Nil(t, err)
+	}
+	postAt(t, ledger, date(time.March, 5), "cash", "revenue", "JPY", "10")
+	report, err := ledger.TrialBalance(date(time.March, 1), date(time.April, 1))
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	assert.Nil(t, report.WriteJSON(&out))
+	assert.JSONEq(t, `{
+		"from": "2024-03-01T00:00:00Z",
+		"to": "2024-04-01T00:00:00Z",
+		"lines": [
+			{"account": "cash", "name": "Cash", "type": "asset", "currency": "JPY",
+				"opening": {"amount": "0", "currency": "JPY"}, "debit": {"amount": "10", "currency": "JPY"},
+				"credit": {"amount": "0", "currency": "JPY"}, "closing": {"amount": "10", "currency": "JPY"}},
+			{"account": "revenue", "type": "income", "currency": "JPY",
+				"opening": {"amount": "0", "currency": "JPY"}, "debit": {"amount": "0", "currency": "JPY"},
+				"credit": {"amount": "10", "currency": "JPY"}, "closing": {"amount": "10", "currency": "JPY"}}
+		],
+		"totals": [
+			{"currency": "JPY", "debit": {"amount": "10", "currency": "JPY"}, "credit": {"amount": "10", "currency": "JPY"}}
+		]
+	}`, out.String())
+
+	// An empty report still lists its lines and totals as arrays.
+	empty, err := newTestLedger(t).TrialBalance(time.Time{}, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	out.Reset()
+	assert.Nil(t, empty.WriteJSON(&out))
+	var decoded map[string]any
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, []any{}, decoded["lines"])
+	assert.Equal(t, []any{}, decoded["totals"])
+}
+
+func TestTrialBalanceListsEveryAccount(t *testing.T) {
+	ledger := newTestLedger(t, "bank", "income")
+	_, err := ledger.CreateAccount(&AccountTemplate{Key: "savings", Name: "Savings", Type: common.Asset, Currency: "EUR"})
+	assert.Nil(t, err)
+	postDeposit(t, ledger, "sale-1", "10.00")
+
+	// Accounts without entries are listed with zero amounts, in their own
+	// currency when they have one, and leave the totals alone.
+	report, err := ledger.TrialBalance(time.Time{}, ledger.now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(report.Lines))
+	assert.Equal(t, TrialBalanceLine{
+		Account:  "savings",
+		Name:     "Savings",
+		Type:     common.Asset,
+		Currency: "EUR",
+		Opening:  money(t, "0", "EUR"),
+		Debit:    money(t, "0", "EUR"),
+		Credit:   money(t, "0", "EUR"),
+		Closing:  money(t, "0", "EUR"),
+	}, report.Lines[2])
+	assert.Equal(t, 1, len(report.Totals))
+
+	// Before the first entry every account is listed with zero amounts.
+	report, err = ledger.TrialBalance(time.Time{}, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	var out bytes.Buffer
+	assert.Nil(t, report.WriteCSV(&out))
+	assert.Equal(t, `account,name,type,currency,opening,debit,credit,closing
+bank,,unclassified,XXX,0,0,0,0
+income,,unclassified,XXX,0,0,0,0
+savings,Savings,asset,EUR,0.00,0.00,0.00,0.00
+`, out.String())
+	assert.Empty(t, report.Totals)
+}
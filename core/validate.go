@@ -0,0 +1,358 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ledger/common"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+var ErrTemplateUnbalanced = errors.New("template does not balance")
+
+// TemplateError collects every problem ValidateTemplate found in a template.
+type TemplateError struct {
+	Type     string
+	Problems []error
+}
+
+func (e *TemplateError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.Error()
+	}
+	return fmt.Sprintf("template %s: %s", e.Type, strings.Join(problems, "; "))
+}
+
+func (e *TemplateError) Unwrap() []error {
+	return e.Problems
+}
+
+// ValidateTemplate checks a transaction template against the chart of
+// accounts in store before any transaction is created from it. It reports
+// malformed lines, duplicate line keys, static account keys missing from the
+// chart and bad or missing parameter declarations, and proves that the lines
+// balance whenever every amount in a currency is a linear expression of the
+// parameters. It returns the sorted names of all parameters the template
+// references, with the fields of list rows named list.field, and a
+// *TemplateError holding every problem found.
+func ValidateTemplate(tt *TransactionTemplate, store Store) ([]string, error) {
+	var problems []error
+	referenced := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	if len(tt.LedgerEntriesTemplate) == 0 {
+		problems = append(problems, errors.New("template has no lines"))
+	}
+	names, err := templateFields(tt.Currency)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("currency: %w", err))
+	}
+	for _, name := range names {
+		referenced[name] = true
+	}
+
+	groups := make(map[string][]*EntryTemplate)
+	var currencies []string
+	provable := true
+	for i := range tt.LedgerEntriesTemplate {
+		// The lines are compiled as copies, tt is left as it is.
+		compiled := tt.LedgerEntriesTemplate[i]
+		line := &compiled
+		if seen[line.Key] {
+			problems = append(problems, &LineError{Key: line.Key, Err: errors.New("duplicate line key")})
+		}
+		seen[line.Key] = true
+
+		// The account and fields of a line are checked even when its
+		// expressions don't compile.
+		compileErr := line.compile()
+		if compileErr != nil {
+			problems = append(problems, compileErr)
+			provable = false
+		}
+		// Fields of a repeated row are referenced as list.field.
+		reference := func(name string) { referenced[name] = true }
+		if line.Repeat != "" {
+			referenced[line.Repeat] = true
+			alias := line.alias() + "."
+			reference = func(name string) {
+				if field, isField := strings.CutPrefix(name, alias); isField {
+					name = line.Repeat + "." + field
+				}
+				referenced[name] = true
+			}
+		}
+		if compileErr == nil {
+			variables(line.amount, reference)
+			if line.condition != nil {
+				variables(line.condition, reference)
+			}
+		}
+		for _, text := range []string{line.AccountKey, line.Currency} {
+			names, err := templateFields(text)
+			if err != nil {
+				problems = append(problems, &LineError{Key: line.Key, Err: err})
+			}
+			for _, name := range names {
+				reference(name)
+			}
+		}
+
+		var account *Account
+		if !strings.Contains(line.AccountKey, "{{") {
+			var err error
+			account, err = store.Account(line.AccountKey)
+			if err != nil {
+				problems = append(problems, &LineError{Key: line.Key, Err: err})
+				provable = false
+				continue
+			}
+		}
+
+		if compileErr != nil {
+			continue
+		}
+
+		// Group the lines by the currency they will post in, as far as it is
+		// known before any parameters are seen.
+		currency := line.Currency
+		if currency == "" {
+			currency = tt.Currency
+		}
+		if currency == "" && account == nil {
+			provable = false
+			continue
+		}
+		if currency == "" {
+			currency = account.Currency
+		}
+		if currency == "" {
+			currency = common.NoCurrency
+		}
+		if _, exists := groups[currency]; !exists {
+			currencies = append(currencies, currency)
+		}
+		groups[currency] = append(groups[currency], line)
+	}
+
+	if provable {
+		for _, currency := range currencies {
+			if err := checkTemplateBalance(groups[currency]); err != nil {
+				problems = append(problems, fmt.Errorf("%s: %w", currency, err))
+			}
+		}
+	}
+
+	names = make([]string, 0, len(referenced))
+	for name := range referenced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	problems = append(problems, tt.validateParams(store, names)...)
+	if len(problems) > 0 {
+		return names, &TemplateError{Type: tt.Type, Problems: problems}
+	}
+	return names, nil
+}
+
+// checkTemplateBalance reports ErrTemplateUnbalanced when the debits minus
+// the credits of lines reduce to a non-zero linear expression. Lines whose
+// amounts are not linear in the parameters, are rounded, conditional or
+// repeated can't be checked.
+func checkTemplateBalance(lines []*EntryTemplate) error {
+	total := linearForm{coefficients: make(map[string]common.Decimal)}
+	for _, line := range lines {
+		if line.Rounding != "" || line.Condition != "" || line.Repeat != "" {
+			return nil
+		}
+		form, ok := linearize(line.amount)
+		if !ok {
+			return nil
+		}
+		if line.Direction == common.Credit {
+			form = form.scale(common.DecimalFromInt(-1))
+		}
+		total = total.add(form)
+	}
+	if !total.isZero() {
+		return fmt.Errorf("%w: debits minus credits is %s", ErrTemplateUnbalanced, total)
+	}
+	return nil
+}
+
+// linearForm is constant + sum(coefficients[name] * name).
+type linearForm struct {
+	coefficients map[string]common.Decimal
+	constant     common.Decimal
+}
+
+func (f linearForm) add(o linearForm) linearForm {
+	sum := linearForm{coefficients: make(map[string]common.Decimal), constant: f.constant.Add(o.constant)}
+	for name, c := range f.coefficients {
+		sum.coefficients[name] = c
+	}
+	for name, c := range o.coefficients {
+		sum.coefficients[name] = sum.coefficients[name].Add(c)
+	}
+	return sum
+}
+
+func (f linearForm) scale(factor common.Decimal) linearForm {
+	scaled := linearForm{coefficients: make(map[string]common.Decimal), constant: f.constant.Mul(factor)}
+	for name, c := range f.coefficients {
+		scaled.coefficients[name] = c.Mul(factor)
+	}
+	return scaled
+}
+
+// isConstant reports whether f depends on no parameter.
+func (f linearForm) isConstant() bool {
+	for _, c := range f.coefficients {
+		if c.Sign() != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f linearForm) isZero() bool {
+	return f.isConstant() && f.constant.Sign() == 0
+}
+
+func (f linearForm) String() string {
+	names := make([]string, 0, len(f.coefficients))
+	for name, c := range f.coefficients {
+		if c.Sign() != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	terms := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		terms = append(terms, fmt.Sprintf("%s*%s", f.coefficients[name], name))
+	}
+	if f.constant.Sign() != 0 || len(terms) == 0 {
+		terms = append(terms, f.constant.String())
+	}
+	return strings.Join(terms, " + ")
+}
+
+// linearize rewrites e as a linear form of its parameters, if it is one.
+func linearize(e expr) (linearForm, bool) {
+	switch e := e.(type) {
+	case numberExpr:
+		return linearForm{constant: e.value}, true
+	case varExpr:
+		return linearForm{coefficients: map[string]common.Decimal{e.name: common.DecimalFromInt(1)}}, true
+	case negExpr:
+		form, ok := linearize(e.operand)
+		return form.scale(common.DecimalFromInt(-1)), ok
+	case percentExpr:
+		form, ok := linearize(e.operand)
+		hundredth, _ := common.ParseDecimal("0.01")
+		return form.scale(hundredth), ok
+	case binaryExpr:
+		left, ok := linearize(e.left)
+		if !ok {
+			return linearForm{}, false
+		}
+		right, ok := linearize(e.right)
+		if !ok {
+			return linearForm{}, false
+		}
+		switch e.op {
+		case "+":
+			return left.add(right), true
+		case "-":
+			return left.add(right.scale(common.DecimalFromInt(-1))), true
+		case "*":
+			if left.isConstant() {
+				return right.scale(left.constant), true
+			}
+			if right.isConstant() {
+				return left.scale(right.constant), true
+			}
+		case "/":
+			if right.isConstant() && right.constant.Sign() != 0 {
+				// Only exact reciprocals keep the form exact.
+				reciprocal, err := common.DecimalFromInt(1).Quo(right.constant)
+				if err == nil && reciprocal.Mul(right.constant).Cmp(common.DecimalFromInt(1)) == 0 {
+					return left.scale(reciprocal), true
+				}
+			}
+		}
+	case callExpr:
+		constant := true
+		for _, arg := range e.args {
+			form, ok := linearize(arg)
+			constant = constant && ok && form.isConstant()
+		}
+		if constant {
+			value, err := e.eval(func(string) (common.Decimal, error) { return common.Decimal{}, nil })
+			return linearForm{constant: value}, err == nil
+		}
+	}
+	return linearForm{}, false
+}
+
+// variables calls visit with the name of every parameter e references.
+func variables(e expr, visit func(name string)) {
+	switch e := e.(type) {
+	case varExpr:
+		visit(e.name)
+	case hasExpr:
+		visit(e.name)
+	case negExpr:
+		variables(e.operand, visit)
+	case notExpr:
+		variables(e.operand, visit)
+	case percentExpr:
+		variables(e.operand, visit)
+	case binaryExpr:
+		variables(e.left, visit)
+		variables(e.right, visit)
+	case callExpr:
+		for _, arg := range e.args {
+			variables(arg, visit)
+		}
+	}
+}
+
+// templateFields returns the {{.name}} fields used by a template string.
+func templateFields(text string) ([]string, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	trees, err := parse.Parse("field", text, "{{", "}}")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			for _, child := range node.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(node.Pipe)
+		case *parse.PipeNode:
+			for _, cmd := range node.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range node.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			names = append(names, strings.Join(node.Ident, "."))
+		}
+	}
+	for _, tree := range trees {
+		walk(tree.Root)
+	}
+	return names, nil
+}
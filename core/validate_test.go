@@ -0,0 +1,154 @@
+package core
+
+import (
+	"errors"
+	"ledger/common"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	ledger := loadAccounts()
+	list, err := UnmarshalLedgerTransactionListTemplate([]byte(`{
+		"types": [{
+			"type": "sell_something",
+			"lines": [
+				{"key": "sales_to_bank", "account": "sales_to_bank", "amount": "{{.sales_before_tax}} + {{.tax_payable}}", "direction": "Debit"},
+				{"key": "user_account", "account": "{{.user_account}}", "amount": "{{.sales_before_tax}}", "direction": "Credit"},
+				{"key": "tax_payable", "account": "tax_payable", "amount": "{{.tax_payable}}", "direction": "Credit"}
+			]
+		}]
+	}`))
+	assert.Nil(t, err)
+
+	params, err := ValidateTemplate(&list.Types[0], ledger.Store())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sales_before_tax", "tax_payable", "user_account"}, params)
+	assert.Nil(t, ledger.LoadTemplates(list))
+}
+
+func TestValidateTemplateReportsEveryProblem(t *testing.T) {
+	ledger := loadAccounts()
+	tt := &TransactionTemplate{
+		Type: "broken",
+		LedgerEntriesTemplate: []EntryTemplate{
+			{Key: "bank", AccountKey: "sales_to_bnak", Amount: "{{.amount}}", Direction: common.Debit},
+			{Key: "income", AccountKey: "income-root", Amount: "{{.amount}} * 90%", Direction: common.Credit},
+			{Key: "income", AccountKey: "tax_payable", Amount: "{{.amount}} * 10% +", Direction: common.Credit},
+			{Key: "user", AccountKey: "{{.user", Amount: "0", Direction: common.Credit},
+		},
+	}
+	_, err := ValidateTemplate(tt, ledger.Store())
+	var templateErr *TemplateError
+	assert.True(t, errors.As(err, &templateErr))
+	assert.Equal(t, "broken", templateErr.Type)
+	assert.Equal(t, 4, len(templateErr.Problems))
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+	var exprErr *ExprError
+	assert.True(t, errors.As(err, &exprErr))
+
+	assert.NotNil(t, ledger.AddTemplate(tt))
+	_, err = ledger.Store().Template("broken", 0)
+	assert.True(t, errors.Is(err, ErrTemplateNotFound))
+}
+
+func TestValidateTemplateBalance(t *testing.T) {
+	ledger := loadAccounts()
+	line := func(key string, account string, amount string, direction common.Direction) EntryTemplate {
+		return EntryTemplate{Key: key, AccountKey: account, Amount: amount, Direction: direction}
+	}
+	tests := []struct {
+		name     string
+		lines    []EntryTemplate
+		balanced bool
+	}{
+		{
+			name: "split by percentage",
+			lines: []EntryTemplate{
+				line("bank", "sales_to_bank", "{{.amount}}", common.Debit),
+				line("income", "income-root", "{{.amount}} * 90%", common.Credit),
+				line("tax", "tax_payable", "{{.amount}} / 10", common.Credit),
+			},
+			balanced: true,
+		},
+		{
+			name: "missing tax",
+			lines: []EntryTemplate{
+				line("bank", "sales_to_bank", "{{.net}} + {{.tax}}", common.Debit),
+				line("income", "income-root", "{{.net}}", common.Credit),
+			},
+		},
+		{
+			name: "constant fee",
+			lines: []EntryTemplate{
+				line("bank", "sales_to_bank", "{{.amount}} - 2 * 0.25", common.Debit),
+				line("income", "income-root", "{{.amount}} - 0.5", common.Credit),
+			},
+			balanced: true,
+		},
+		{
+			name: "non-linear amounts are not checked",
+			lines: []EntryTemplate{
+				line("bank", "sales_to_bank", "{{.price}} * {{.quantity}}", common.Debit),
+				line("income", "income-root", "{{.total}}", common.Credit),
+			},
+			balanced: true,
+		},
+		{
+			name: "currencies balance separately",
+			lines: []EntryTemplate{
+				line("bank", "sales_to_bank", "{{.amount}}", common.Debit),
+				{Key: "income", AccountKey: "income-root", Amount: "{{.amount}}", Direction: common.Credit, Currency: "EUR"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		_, err := ValidateTemplate(&TransactionTemplate{Type: tt.name, LedgerEntriesTemplate: tt.lines}, ledger.Store())
+		if tt.balanced {
+			assert.Nil(t, err, tt.name)
+		} else {
+			assert.True(t, errors.Is(err, ErrTemplateUnbalanced), tt.name)
+		}
+	}
+}
+
+func TestTemplatesCompiledWhenAdded(t *testing.T) {
+	ledger := loadAccounts()
+	tt := &TransactionTemplate{
+		Type: "sale",
+		LedgerEntriesTemplate: []EntryTemplate{
+			{Key: "bank", AccountKey: "sales_to_bank", Amount: "{{.amount}}", Direction: common.Debit},
+			{Key: "income", AccountKey: "income-root", Amount: "{{.amount}}", Direction: common.Credit, Condition: "amount > 0"},
+		},
+	}
+	_, err := ValidateTemplate(tt, ledger.Store())
+	assert.Nil(t, err)
+	assert.Nil(t, tt.LedgerEntriesTemplate[0].amount)
+
+	assert.Nil(t, ledger.AddTemplate(tt))
+	assert.Nil(t, tt.LedgerEntriesTemplate[1].condition)
+	stored, err := ledger.Store().Template("sale", 0)
+	assert.Nil(t, err)
+	for _, line := range stored.LedgerEntriesTemplate {
+		assert.NotNil(t, line.amount)
+	}
+	assert.NotNil(t, stored.LedgerEntriesTemplate[1].condition)
+
+	// Transactions are created from the stored template concurrently, which
+	// only reads it.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ledger.CreateTransaction(TransactionInput{Type: "sale", Parameters: map[string]string{"amount": "5"}})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	balance, err := ledger.Balance("sales_to_bank")
+	assert.Nil(t, err)
+	assert.Equal(t, "40", balance.Posted.Decimal().String())
+}